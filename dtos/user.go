@@ -1,6 +1,9 @@
 package dtos
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type User struct {
 	Id      int64     `json:"id"`
@@ -16,10 +19,26 @@ type CreateUserCmd struct {
 	Result *User
 }
 
-type ListUsersCmd struct {
-	Limit  int64
-	Page   int64
-	Result UsersResult
+func (cmd *CreateUserCmd) Validate() error {
+	if cmd.Name == "" || cmd.Email == "" {
+		return errors.New("Name and email are required")
+	}
+	return nil
+}
+
+type UserCreatedEvent struct {
+	Id    int64  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func (event *UserCreatedEvent) EventName() string {
+	return "user.created"
+}
+
+type ListUsersQuery struct {
+	Limit int64
+	Page  int64
 }
 
 type UsersResult struct {
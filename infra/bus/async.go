@@ -0,0 +1,262 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-microservice/infra/server"
+	"hash/fnv"
+	"reflect"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Ordering of the events published with PublishAsync
+type Ordering int
+
+const (
+	// Unordered events are delivered concurrently by any worker
+	Unordered Ordering = iota
+	// Ordered events of a type are delivered one after the other in publish order
+	Ordered
+	// OrderedByKey events are delivered in publish order per OrderingKey(),
+	// events with different keys are delivered concurrently
+	OrderedByKey
+)
+
+var (
+	publisher        *asyncPublisher
+	ErrBusStopped    = errors.New("Bus is stopped")
+	ErrMissingKey    = errors.New("Event has no ordering key")
+	ErrQueueNotReady = errors.New("Bus workers are not started")
+)
+
+// KeyedMsg is implemented by events published OrderedByKey
+type KeyedMsg interface {
+	OrderingKey() string
+}
+
+type envelope struct {
+	ctx context.Context
+	msg Msg
+}
+
+// asyncPublisher delivers PublishAsync events to the listeners on a pool of
+// workers. Unordered events share a queue, ordered events are routed to the
+// queue of one worker so that they are delivered in order.
+type asyncPublisher struct {
+	mu        sync.RWMutex
+	ordering  map[reflect.Type]Ordering
+	workers   int
+	queue     chan envelope
+	ordered   []chan envelope
+	stopped   bool
+	closing   chan struct{}
+	sending   sync.WaitGroup
+	drained   chan struct{}
+	waitGroup sync.WaitGroup
+}
+
+func init() {
+	publisher = newAsyncPublisher()
+	server.RegisterService(publisher, server.Low)
+}
+
+func newAsyncPublisher() *asyncPublisher {
+	return &asyncPublisher{
+		ordering: make(map[reflect.Type]Ordering),
+		closing:  make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+}
+
+// PublishAsync queues the msg for delivery to its listeners and returns once
+// queued, blocking while the queue is full until ctx is done. Listener errors
+// are logged. Listeners get a context carrying the trace of ctx but not its
+// cancellation.
+func PublishAsync(ctx context.Context, msg Msg) error {
+	return publisher.enqueue(ctx, msg)
+}
+
+// SetOrdering of the events of the type of msg published with PublishAsync,
+// events are Unordered by default.
+func SetOrdering(msg Msg, ordering Ordering) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+	publisher.ordering[msgType] = ordering
+	return nil
+}
+
+func (c *asyncPublisher) Init() error {
+	viper.SetDefault("bus.workers", 4)
+	viper.SetDefault("bus.queuesize", 1024)
+	c.workers = viper.GetInt("bus.workers")
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	queueSize := viper.GetInt("bus.queuesize")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queue = make(chan envelope, queueSize)
+	c.ordered = make([]chan envelope, c.workers)
+	for i := range c.ordered {
+		c.ordered[i] = make(chan envelope, queueSize)
+	}
+	return nil
+}
+
+func (c *asyncPublisher) OnConfig() {
+}
+
+// Run the workers until the queues are drained by Stop or ctx is done.
+func (c *asyncPublisher) Run(ctx context.Context) error {
+	log.WithField("Workers", c.workers).Info("Bus workers started")
+	for i := 0; i < c.workers; i++ {
+		c.waitGroup.Add(1)
+		go c.work(ctx, c.ordered[i])
+	}
+	c.waitGroup.Wait()
+	close(c.drained)
+	return nil
+}
+
+// Stop accepting events and wait for the queued ones to be delivered. The
+// publishers waiting for room in a full queue give up with ErrBusStopped, the
+// queues are closed once none is sending anymore.
+func (c *asyncPublisher) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	stopping := !c.stopped && c.queue != nil
+	if stopping {
+		c.stopped = true
+		close(c.closing)
+	}
+	c.mu.Unlock()
+	if stopping {
+		go func() {
+			c.sending.Wait()
+			close(c.queue)
+			for _, queue := range c.ordered {
+				close(queue)
+			}
+		}()
+	}
+
+	select {
+	case <-c.drained:
+		return nil
+	case <-ctx.Done():
+		log.WithField("Queued", c.queued()).Error("Bus stopped before delivering all events")
+		return ctx.Err()
+	}
+}
+
+// enqueue msg, the lock is not held while waiting for room in the queue so
+// that Stop is not blocked by the publishers.
+func (c *asyncPublisher) enqueue(ctx context.Context, msg Msg) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	queue, err := c.route(msgType, msg)
+	if err != nil {
+		return err
+	}
+	defer c.sending.Done()
+
+	item := envelope{
+		ctx: trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx)),
+		msg: msg,
+	}
+	select {
+	case queue <- item:
+		return nil
+	case <-c.closing:
+		return ErrBusStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// route msg to its queue and count it as sending until queued, so that the
+// queue is not closed meanwhile.
+func (c *asyncPublisher) route(msgType reflect.Type, msg Msg) (chan envelope, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stopped {
+		return nil, ErrBusStopped
+	}
+	if c.queue == nil {
+		return nil, ErrQueueNotReady
+	}
+
+	queue := c.queue
+	switch c.ordering[msgType] {
+	case Ordered:
+		queue = c.ordered[c.worker(msgType.String())]
+	case OrderedByKey:
+		keyed, ok := msg.(KeyedMsg)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingKey, msgType)
+		}
+		queue = c.ordered[c.worker(msgType.String()+"/"+keyed.OrderingKey())]
+	}
+	c.sending.Add(1)
+	return queue, nil
+}
+
+// worker index owning the ordered queue of key
+func (c *asyncPublisher) worker(key string) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(len(c.ordered)))
+}
+
+// work delivers the events of its ordered queue and of the shared queue until
+// both are closed and drained, or ctx is done.
+func (c *asyncPublisher) work(ctx context.Context, ordered chan envelope) {
+	defer c.waitGroup.Done()
+	queue := c.queue
+	for ordered != nil || queue != nil {
+		select {
+		case item, ok := <-ordered:
+			if !ok {
+				ordered = nil
+				continue
+			}
+			c.deliver(item)
+		case item, ok := <-queue:
+			if !ok {
+				queue = nil
+				continue
+			}
+			c.deliver(item)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *asyncPublisher) deliver(item envelope) {
+	if err := instance.publishCtx(item.ctx, item.msg); err != nil {
+		log.WithFields(log.Fields{
+			"Event": fmt.Sprintf("%T", item.msg),
+			"Error": err,
+		}).Error("Async event listener failed")
+	}
+}
+
+func (c *asyncPublisher) queued() int {
+	queued := len(c.queue)
+	for _, queue := range c.ordered {
+		queued += len(queue)
+	}
+	return queued
+}
@@ -0,0 +1,89 @@
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// startPublisher of one worker and a queue of one event, run until ctx is done
+func startPublisher(t *testing.T, ctx context.Context) *asyncPublisher {
+	viper.Set("bus.workers", 1)
+	viper.Set("bus.queuesize", 1)
+	t.Cleanup(func() {
+		viper.Set("bus.workers", nil)
+		viper.Set("bus.queuesize", nil)
+	})
+	p := newAsyncPublisher()
+	if err := p.Init(); err != nil {
+		t.Fatal(err)
+	}
+	go p.Run(ctx)
+	return p
+}
+
+func TestStopWithFullQueue(t *testing.T) {
+	useBus(t)
+	// the workers stop with the server before the publisher is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	p := startPublisher(t, ctx)
+	cancel()
+	<-p.drained
+
+	if err := p.enqueue(context.Background(), &pingedEvent{}); err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- p.enqueue(context.Background(), &pingedEvent{})
+	}()
+	select {
+	case err := <-blocked:
+		t.Fatalf("expected the publisher to wait for room, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+	defer stopCancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- p.Stop(stopCtx) }()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop blocked by a publisher waiting for room")
+	}
+	if err := <-blocked; err != ErrBusStopped {
+		t.Fatalf("expected ErrBusStopped for the waiting publisher, got %v", err)
+	}
+	if err := p.enqueue(context.Background(), &pingedEvent{}); err != ErrBusStopped {
+		t.Fatalf("expected ErrBusStopped once stopped, got %v", err)
+	}
+}
+
+func TestStopDeliversQueuedEvents(t *testing.T) {
+	useBus(t)
+	delivered := make(chan string, 3)
+	if err := AddEventListener(func(event *pingedEvent) error {
+		delivered <- event.Name
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	p := startPublisher(t, context.Background())
+	for _, name := range []string{"a", "b", "c"} {
+		if err := p.enqueue(context.Background(), &pingedEvent{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := p.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(delivered) != 3 {
+		t.Fatalf("expected the 3 queued events delivered, got %d", len(delivered))
+	}
+}
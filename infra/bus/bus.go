@@ -3,12 +3,22 @@ package bus
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 )
 
 var (
-	instance          Bus
-	ErrMissingHandler = errors.New("Handler Not Found")
+	instance            Bus
+	ErrMissingHandler   = errors.New("Handler Not Found")
+	ErrInvalidHandler   = errors.New("Invalid handler")
+	ErrDuplicateHandler = errors.New("Handler already registered")
+	ErrInvalidMessage   = errors.New("Invalid message")
+	ErrHandlerPanic     = errors.New("Handler panicked")
+	ErrDuplicateEvent   = errors.New("Event name already registered")
+
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
 )
 
 type HandlerFunc interface{}
@@ -21,61 +31,166 @@ type Bus interface {
 	dispatch(msg Msg) error
 	dispatchCtx(ctx context.Context, msg Msg) error
 	publish(msg Msg) error
-	addHandler(handler HandlerFunc)
-	addHandlerCtx(handler HandlerFunc)
-	addEventListener(handler HandlerFunc)
+	publishCtx(ctx context.Context, msg Msg) error
+	addHandler(handler HandlerFunc) error
+	addHandlerCtx(handler HandlerFunc) error
+	addEventListener(handler HandlerFunc, options []ListenerOption) error
+	addEventListenerCtx(handler HandlerFunc, options []ListenerOption) error
+	use(msgType reflect.Type, middleware ...Middleware)
+	wrap(msgType reflect.Type, next Handler) Handler
+	registerEvent(msgType reflect.Type) error
+	eventType(name string) (reflect.Type, bool)
+	listener(msgType reflect.Type, name string) (*listener, bool)
+	attempt(ctx context.Context, msgType reflect.Type, l *listener, msg Msg) error
+	handlerInfos() []HandlerInfo
 }
 
+// Handlers and listeners are keyed by the message type, messages with the
+// same name in different packages do not collide.
 type bus struct {
-	handlers        map[string]HandlerFunc
-	handlersWithCtx map[string]HandlerFunc
-	listeners       map[string][]HandlerFunc
+	mu               sync.RWMutex
+	handlers         map[reflect.Type]HandlerFunc
+	handlersWithCtx  map[reflect.Type]HandlerFunc
+	listeners        map[reflect.Type][]*listener
+	listenersWithCtx map[reflect.Type][]*listener
+	middleware       []Middleware
+	typeMiddleware   map[reflect.Type][]Middleware
+	events           map[string]reflect.Type
+	legacyEvents     map[string]reflect.Type
 }
 
+// Dispatch the msg to its handler, handlers registered with AddHandlerCtx
+// get context.Background(). Use DispatchCtx on behalf of a request.
 func Dispatch(msg Msg) error {
 	return instance.dispatch(msg)
 }
 
+// DispatchCtx the msg to its handler, the handler gets ctx when registered
+// with AddHandlerCtx. Returns the ctx error without dispatching once ctx is
+// done.
 func DispatchCtx(ctx context.Context, msg Msg) error {
 	return instance.dispatchCtx(ctx, msg)
 }
 
+// Publish the msg to its listeners, listeners registered with
+// AddEventListenerCtx get context.Background(). Every listener is called even
+// when some fail, their errors are returned as a MultiError.
 func Publish(msg Msg) error {
 	return instance.publish(msg)
 }
 
-func AddHandler(handler HandlerFunc) {
-	instance.addHandler(handler)
+// PublishCtx the msg to its listeners, listeners get ctx when registered with
+// AddEventListenerCtx.
+func PublishCtx(ctx context.Context, msg Msg) error {
+	return instance.publishCtx(ctx, msg)
+}
+
+// AddHandler registers func(*Msg) error as the only handler of Msg.
+func AddHandler(handler HandlerFunc) error {
+	return instance.addHandler(handler)
+}
+
+// AddHandlerCtx registers func(context.Context, *Msg) error as the only
+// handler of Msg.
+func AddHandlerCtx(handler HandlerFunc) error {
+	return instance.addHandlerCtx(handler)
 }
 
-func AddHandlerCtx(handler HandlerFunc) {
-	instance.addHandlerCtx(handler)
+// AddEventListener registers func(*Msg) error as a listener of Msg, the
+// options set its retry and dead letter policy.
+func AddEventListener(handler HandlerFunc, options ...ListenerOption) error {
+	return instance.addEventListener(handler, options)
 }
 
-func AddEventListener(handler HandlerFunc) {
-	instance.addEventListener(handler)
+// AddEventListenerCtx registers func(context.Context, *Msg) error as a
+// listener of Msg, the options set its retry, timeout and dead letter policy.
+func AddEventListenerCtx(handler HandlerFunc, options ...ListenerOption) error {
+	return instance.addEventListenerCtx(handler, options)
 }
 
 func init() {
-	instance = &bus{
-		handlers:        make(map[string]HandlerFunc),
-		handlersWithCtx: make(map[string]HandlerFunc),
-		listeners:       make(map[string][]HandlerFunc),
+	instance = newBus()
+}
+
+func newBus() *bus {
+	return &bus{
+		handlers:         make(map[reflect.Type]HandlerFunc),
+		handlersWithCtx:  make(map[reflect.Type]HandlerFunc),
+		listeners:        make(map[reflect.Type][]*listener),
+		listenersWithCtx: make(map[reflect.Type][]*listener),
+		typeMiddleware:   make(map[reflect.Type][]Middleware),
+		events:           make(map[string]reflect.Type),
+		legacyEvents:     make(map[string]reflect.Type),
 	}
 }
 
 func (bus *bus) dispatchCtx(ctx context.Context, msg Msg) error {
-	var msgName = reflect.TypeOf(msg).Elem().Name()
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	var msgName = msgType.String()
+
+	bus.mu.RLock()
+	var handler = bus.handlersWithCtx[msgType]
+	withCtx := true
 
-	var handler = bus.handlersWithCtx[msgName]
 	if handler == nil {
-		return ErrMissingHandler
+		withCtx = false
+		handler = bus.handlers[msgType]
 	}
+	bus.mu.RUnlock()
 
-	var params = []reflect.Value{}
-	params = append(params, reflect.ValueOf(ctx))
-	params = append(params, reflect.ValueOf(msg))
+	if handler == nil {
+		return fmt.Errorf("%w: %s", ErrMissingHandler, msgName)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
+	invoke := bus.chain(msgType, handler, withCtx)
+	return invoke(withInvocation(ctx, KindDispatch, msgName), msg)
+}
+
+func (bus *bus) dispatch(msg Msg) error {
+	return bus.dispatchCtx(context.Background(), msg)
+}
+
+func (bus *bus) publishCtx(ctx context.Context, msg Msg) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	var msgName = msgType.String()
+
+	bus.mu.RLock()
+	var listeners = bus.listeners[msgType]
+	var listenersWithCtx = bus.listenersWithCtx[msgType]
+	bus.mu.RUnlock()
+
+	ctx = withInvocation(ctx, KindPublish, msgName)
+	errs := make([]error, 0)
+	for _, l := range listeners {
+		errs = append(errs, bus.deliver(ctx, msgType, l, msg))
+	}
+
+	for _, l := range listenersWithCtx {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		errs = append(errs, bus.deliver(ctx, msgType, l, msg))
+	}
+	errs = append(errs, bridge.forward(ctx, msgType, msg))
+	return joinErrors(errs...)
+}
+
+func (bus *bus) publish(msg Msg) error {
+	return bus.publishCtx(context.Background(), msg)
+}
+
+func call(handler HandlerFunc, params []reflect.Value) error {
 	ret := reflect.ValueOf(handler).Call(params)
 	err := ret[0].Interface()
 	if err == nil {
@@ -84,70 +199,159 @@ func (bus *bus) dispatchCtx(ctx context.Context, msg Msg) error {
 	return err.(error)
 }
 
-func (bus *bus) dispatch(msg Msg) error {
-	var msgName = reflect.TypeOf(msg).Elem().Name()
+// messageType of a msg, messages must be pointers.
+func messageType(msg Msg) (reflect.Type, error) {
+	msgType := reflect.TypeOf(msg)
+	if msgType == nil || msgType.Kind() != reflect.Ptr || reflect.ValueOf(msg).IsNil() {
+		return nil, fmt.Errorf("%w: %T is not a non nil pointer", ErrInvalidMessage, msg)
+	}
+	return msgType.Elem(), nil
+}
 
-	var handler = bus.handlersWithCtx[msgName]
-	withCtx := true
+// handlerMessageType validates the handler is func(*Msg) error or, withCtx,
+// func(context.Context, *Msg) error and returns the type of Msg.
+func handlerMessageType(handler HandlerFunc, withCtx bool) (reflect.Type, error) {
+	handlerType := reflect.TypeOf(handler)
+	signature := "func(*Msg) error"
+	params := 1
+	if withCtx {
+		signature = "func(context.Context, *Msg) error"
+		params = 2
+	}
 
-	if handler == nil {
-		withCtx = false
-		handler = bus.handlers[msgName]
+	if handlerType == nil || handlerType.Kind() != reflect.Func || reflect.ValueOf(handler).IsNil() {
+		return nil, fmt.Errorf("%w: %T is not a %s", ErrInvalidHandler, handler, signature)
+	}
+	if handlerType.NumIn() != params || handlerType.IsVariadic() ||
+		handlerType.NumOut() != 1 || handlerType.Out(0) != errorType {
+		return nil, fmt.Errorf("%w: %s is not a %s", ErrInvalidHandler, handlerType, signature)
 	}
+	if withCtx && handlerType.In(0) != contextType {
+		return nil, fmt.Errorf("%w: %s is not a %s", ErrInvalidHandler, handlerType, signature)
+	}
+	msgType := handlerType.In(params - 1)
+	if msgType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("%w: %s is not a %s", ErrInvalidHandler, handlerType, signature)
+	}
+	return msgType.Elem(), nil
+}
 
-	if handler == nil {
-		return ErrMissingHandler
+func (bus *bus) addHandler(handler HandlerFunc) error {
+	return bus.registerHandler(handler, false)
+}
+
+func (bus *bus) addHandlerCtx(handler HandlerFunc) error {
+	return bus.registerHandler(handler, true)
+}
+
+func (bus *bus) registerHandler(handler HandlerFunc, withCtx bool) error {
+	msgType, err := handlerMessageType(handler, withCtx)
+	if err != nil {
+		return err
 	}
 
-	var params = []reflect.Value{}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	_, exists := bus.handlers[msgType]
+	_, existsWithCtx := bus.handlersWithCtx[msgType]
+	if exists || existsWithCtx {
+		return fmt.Errorf("%w: %s", ErrDuplicateHandler, msgType)
+	}
 	if withCtx {
-		params = append(params, reflect.ValueOf(context.Background()))
+		bus.handlersWithCtx[msgType] = handler
+	} else {
+		bus.handlers[msgType] = handler
 	}
-	params = append(params, reflect.ValueOf(msg))
+	return nil
+}
 
-	ret := reflect.ValueOf(handler).Call(params)
-	err := ret[0].Interface()
-	if err == nil {
-		return nil
+func (bus *bus) addEventListener(handler HandlerFunc, options []ListenerOption) error {
+	eventType, err := handlerMessageType(handler, false)
+	if err != nil {
+		return err
 	}
-	return err.(error)
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if err := bus.addEvent(eventType); err != nil {
+		return err
+	}
+	bus.listeners[eventType] = append(bus.listeners[eventType], newListener(handler, false, options))
+	return nil
 }
 
-func (bus *bus) publish(msg Msg) error {
-	var msgName = reflect.TypeOf(msg).Elem().Name()
-	var listeners = bus.listeners[msgName]
+func (bus *bus) addEventListenerCtx(handler HandlerFunc, options []ListenerOption) error {
+	eventType, err := handlerMessageType(handler, true)
+	if err != nil {
+		return err
+	}
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if err := bus.addEvent(eventType); err != nil {
+		return err
+	}
+	bus.listenersWithCtx[eventType] = append(bus.listenersWithCtx[eventType], newListener(handler, true, options))
+	return nil
+}
 
-	var params = make([]reflect.Value, 1)
-	params[0] = reflect.ValueOf(msg)
+// registerEvent so that events serialized by name can be decoded
+func (bus *bus) registerEvent(msgType reflect.Type) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return bus.addEvent(msgType)
+}
 
-	for _, listenerHandler := range listeners {
-		ret := reflect.ValueOf(listenerHandler).Call(params)
-		err := ret[0].Interface()
-		if err != nil {
-			return err.(error)
+// addEvent under its typeName, another type of the same name is rejected.
+// Called with mu held.
+func (bus *bus) addEvent(msgType reflect.Type) error {
+	name := typeName(msgType)
+	if registered, ok := bus.events[name]; ok {
+		if registered != msgType {
+			return fmt.Errorf("%w: %s", ErrDuplicateEvent, name)
 		}
+		return nil
+	}
+	bus.events[name] = msgType
+	// names stored before the package path was part of them, dropped once
+	// ambiguous
+	legacy := msgType.String()
+	if _, ok := bus.legacyEvents[legacy]; ok {
+		bus.legacyEvents[legacy] = nil
+	} else {
+		bus.legacyEvents[legacy] = msgType
 	}
 	return nil
 }
 
-func (bus *bus) addHandler(handler HandlerFunc) {
-	handlerType := reflect.TypeOf(handler)
-	queryTypeName := handlerType.In(0).Elem().Name()
-	bus.handlers[queryTypeName] = handler
+// listener of msgType named name
+func (bus *bus) listener(msgType reflect.Type, name string) (*listener, bool) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	for _, listeners := range [][]*listener{bus.listeners[msgType], bus.listenersWithCtx[msgType]} {
+		for _, l := range listeners {
+			if l.name == name {
+				return l, true
+			}
+		}
+	}
+	return nil, false
 }
 
-func (bus *bus) addHandlerCtx(handler HandlerFunc) {
-	handlerType := reflect.TypeOf(handler)
-	queryTypeName := handlerType.In(1).Elem().Name()
-	bus.handlersWithCtx[queryTypeName] = handler
+func (bus *bus) eventType(name string) (reflect.Type, bool) {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	if msgType, ok := bus.events[name]; ok {
+		return msgType, true
+	}
+	msgType := bus.legacyEvents[name]
+	return msgType, msgType != nil
 }
 
-func (bus *bus) addEventListener(handler HandlerFunc) {
-	handlerType := reflect.TypeOf(handler)
-	eventName := handlerType.In(0).Elem().Name()
-	_, exists := bus.listeners[eventName]
-	if !exists {
-		bus.listeners[eventName] = make([]HandlerFunc, 0)
+// typeName of msgType with its package path, like
+// go-microservice/dtos.UserCreatedEvent, under which events are stored and
+// decoded. Unlike its String(), types of packages with the same name differ.
+func typeName(msgType reflect.Type) string {
+	if msgType.Name() == "" || msgType.PkgPath() == "" {
+		return msgType.String()
 	}
-	bus.listeners[eventName] = append(bus.listeners[eventName], handler)
+	return msgType.PkgPath() + "." + msgType.Name()
 }
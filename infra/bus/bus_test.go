@@ -0,0 +1,176 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"reflect"
+	"testing"
+	texttemplate "text/template"
+)
+
+type pingCmd struct {
+	Result string
+}
+
+type pingedEvent struct {
+	Name string
+}
+
+// useBus replaces the bus instance for the test
+func useBus(t *testing.T) {
+	previous := instance
+	instance = newBus()
+	t.Cleanup(func() { instance = previous })
+}
+
+func TestAddHandlerSignature(t *testing.T) {
+	useBus(t)
+	invalid := []HandlerFunc{
+		nil,
+		"not a func",
+		(func(*pingCmd) error)(nil),
+		func(cmd pingCmd) error { return nil },
+		func(cmd *pingCmd) {},
+		func(cmd *pingCmd) string { return "" },
+		func(cmd *pingCmd, other *pingCmd) error { return nil },
+		func(cmds ...*pingCmd) error { return nil },
+	}
+	for _, handler := range invalid {
+		if err := AddHandler(handler); !errors.Is(err, ErrInvalidHandler) {
+			t.Errorf("AddHandler(%T) expected ErrInvalidHandler, got %v", handler, err)
+		}
+	}
+	if err := AddHandlerCtx(func(cmd *pingCmd) error { return nil }); !errors.Is(err, ErrInvalidHandler) {
+		t.Errorf("AddHandlerCtx without context expected ErrInvalidHandler, got %v", err)
+	}
+	if err := AddHandlerCtx(func(cmd *pingCmd, ctx context.Context) error { return nil }); !errors.Is(err, ErrInvalidHandler) {
+		t.Errorf("AddHandlerCtx with context last expected ErrInvalidHandler, got %v", err)
+	}
+	if err := AddEventListener(func(ctx context.Context, event *pingedEvent) error { return nil }); !errors.Is(err, ErrInvalidHandler) {
+		t.Errorf("AddEventListener with context expected ErrInvalidHandler, got %v", err)
+	}
+}
+
+func TestAddHandlerDuplicate(t *testing.T) {
+	useBus(t)
+	if err := AddHandler(func(cmd *pingCmd) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddHandler(func(cmd *pingCmd) error { return nil }); !errors.Is(err, ErrDuplicateHandler) {
+		t.Errorf("expected ErrDuplicateHandler, got %v", err)
+	}
+	if err := AddHandlerCtx(func(ctx context.Context, cmd *pingCmd) error { return nil }); !errors.Is(err, ErrDuplicateHandler) {
+		t.Errorf("expected ErrDuplicateHandler for a ctx handler of the same message, got %v", err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	useBus(t)
+	if err := Dispatch(&pingCmd{}); !errors.Is(err, ErrMissingHandler) {
+		t.Fatalf("expected ErrMissingHandler, got %v", err)
+	}
+	if err := Dispatch(pingCmd{}); !errors.Is(err, ErrInvalidMessage) {
+		t.Fatalf("expected ErrInvalidMessage for a value, got %v", err)
+	}
+	if err := AddHandlerCtx(func(ctx context.Context, cmd *pingCmd) error {
+		cmd.Result = "pong"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &pingCmd{}
+	if err := DispatchCtx(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Result != "pong" {
+		t.Fatalf("handler not called, result %q", cmd.Result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := DispatchCtx(ctx, &pingCmd{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestPublishCallsEveryListener(t *testing.T) {
+	useBus(t)
+	failure := errors.New("failed")
+	called := 0
+	if err := AddEventListener(func(event *pingedEvent) error {
+		called++
+		return failure
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddEventListenerCtx(func(ctx context.Context, event *pingedEvent) error {
+		called++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err := Publish(&pingedEvent{Name: "ping"})
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected the listener error, got %v", err)
+	}
+	if called != 2 {
+		t.Fatalf("expected 2 listeners called, got %d", called)
+	}
+}
+
+func TestEventTypeByPackagePath(t *testing.T) {
+	useBus(t)
+	b := instance.(*bus)
+	textType := reflect.TypeOf(texttemplate.Template{})
+	htmlType := reflect.TypeOf(htmltemplate.Template{})
+	if textType.String() != htmlType.String() {
+		t.Fatalf("expected types named alike, got %s and %s", textType, htmlType)
+	}
+	if err := b.registerEvent(textType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.registerEvent(htmlType); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.registerEvent(textType); err != nil {
+		t.Fatalf("registering a type again failed: %v", err)
+	}
+
+	if got, ok := b.eventType("text/template.Template"); !ok || got != textType {
+		t.Errorf("text/template.Template decoded as %v", got)
+	}
+	if got, ok := b.eventType("html/template.Template"); !ok || got != htmlType {
+		t.Errorf("html/template.Template decoded as %v", got)
+	}
+	if got, ok := b.eventType("template.Template"); ok {
+		t.Errorf("ambiguous name decoded as %v", got)
+	}
+}
+
+func TestEventTypeDuplicateName(t *testing.T) {
+	useBus(t)
+	b := instance.(*bus)
+	if err := b.registerEvent(reflect.TypeOf(pingedEvent{})); err != nil {
+		t.Fatal(err)
+	}
+	// a type of the same package and name, declared in a function
+	type pingedEvent struct{}
+	if err := b.registerEvent(reflect.TypeOf(pingedEvent{})); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatalf("expected ErrDuplicateEvent, got %v", err)
+	}
+	if err := AddEventListener(func(event *pingedEvent) error { return nil }); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatalf("expected ErrDuplicateEvent for a listener, got %v", err)
+	}
+}
+
+func TestLegacyEventName(t *testing.T) {
+	useBus(t)
+	b := instance.(*bus)
+	if err := b.registerEvent(reflect.TypeOf(pingedEvent{})); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := b.eventType("bus.pingedEvent"); !ok || got != reflect.TypeOf(pingedEvent{}) {
+		t.Fatalf("name stored before the package path decoded as %v", got)
+	}
+}
@@ -0,0 +1,254 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-microservice/infra/dbs/postgres"
+	"go-microservice/infra/server"
+	"go-microservice/infra/tracing"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	deadLetters              *deadLetterService
+	ErrDeadLettersNotStored  = errors.New("Dead letter sink does not store dead letters")
+	ErrDeadLetterNotFound    = errors.New("Dead letter not found")
+	ErrUnknownDeadLetterSink = errors.New("Unknown dead letter sink")
+)
+
+// DeadLetter is an event a listener failed to handle on every attempt
+type DeadLetter struct {
+	Id        int64     `json:"id"`
+	EventType string    `json:"event_type"`
+	Listener  string    `json:"listener"`
+	Payload   string    `json:"payload"`
+	Headers   string    `json:"headers"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	Created   time.Time `json:"created"`
+}
+
+// DeadLetterSink receives the dead letters of the listeners added
+// WithDeadLetter.
+type DeadLetterSink interface {
+	Put(ctx context.Context, letter *DeadLetter) error
+}
+
+// DeadLetterStore is a sink keeping the dead letters to be listed and
+// replayed.
+type DeadLetterStore interface {
+	DeadLetterSink
+	List(ctx context.Context, limit int, offset int) ([]*DeadLetter, error)
+	Get(ctx context.Context, id int64) (*DeadLetter, error)
+	Remove(ctx context.Context, id int64) error
+}
+
+// DeadLetterFunc is a sink calling the func
+type DeadLetterFunc func(ctx context.Context, letter *DeadLetter) error
+
+func (f DeadLetterFunc) Put(ctx context.Context, letter *DeadLetter) error {
+	return f(ctx, letter)
+}
+
+// LogSink logs the dead letters, used for bus.deadletter.sink log
+type LogSink struct{}
+
+func (LogSink) Put(ctx context.Context, letter *DeadLetter) error {
+	log.WithFields(log.Fields{
+		"Event":    letter.EventType,
+		"Listener": letter.Listener,
+		"Payload":  letter.Payload,
+		"Attempts": letter.Attempts,
+		"Error":    letter.Error,
+	}).Error("Event dead lettered")
+	return nil
+}
+
+// PostgresSink stores the dead letters in the dead_letter table, used for
+// bus.deadletter.sink postgres
+type PostgresSink struct{}
+
+func (PostgresSink) Put(ctx context.Context, letter *DeadLetter) error {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Create(letter).Error
+}
+
+func (PostgresSink) List(ctx context.Context, limit int, offset int) ([]*DeadLetter, error) {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*DeadLetter, 0)
+	err = db.Order("id").Offset(offset).Limit(limit).Find(&letters).Error
+	return letters, err
+}
+
+func (PostgresSink) Get(ctx context.Context, id int64) (*DeadLetter, error) {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	letter := &DeadLetter{}
+	query := db.Where("id = ?", id).First(letter)
+	if query.RecordNotFound() {
+		return nil, fmt.Errorf("%w: %d", ErrDeadLetterNotFound, id)
+	}
+	return letter, query.Error
+}
+
+func (PostgresSink) Remove(ctx context.Context, id int64) error {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Where("id = ?", id).Delete(&DeadLetter{}).Error
+}
+
+// deadLetterService holds the sink configured in bus.deadletter.sink or set
+// with SetDeadLetterSink.
+type deadLetterService struct {
+	mu   sync.RWMutex
+	sink DeadLetterSink
+}
+
+func init() {
+	deadLetters = &deadLetterService{
+		sink: LogSink{},
+	}
+	server.RegisterService(deadLetters, server.Low, "postgres")
+	addDeadLetterMigrations()
+}
+
+// SetDeadLetterSink replaces the sink of bus.deadletter.sink
+func SetDeadLetterSink(sink DeadLetterSink) {
+	deadLetters.mu.Lock()
+	defer deadLetters.mu.Unlock()
+	deadLetters.sink = sink
+}
+
+// ListDeadLetters kept by the sink, oldest first
+func ListDeadLetters(ctx context.Context, limit int, offset int) ([]*DeadLetter, error) {
+	store, err := deadLetters.store()
+	if err != nil {
+		return nil, err
+	}
+	return store.List(ctx, limit, offset)
+}
+
+// ReplayDeadLetter delivers the dead letter to its listener again following
+// its policy but without dead lettering it, it is removed once handled.
+func ReplayDeadLetter(ctx context.Context, id int64) error {
+	store, err := deadLetters.store()
+	if err != nil {
+		return err
+	}
+	letter, err := store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	msgType, ok := instance.eventType(letter.EventType)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMissingHandler, letter.EventType)
+	}
+	target, ok := instance.listener(msgType, letter.Listener)
+	if !ok {
+		return fmt.Errorf("%w: %s of %s", ErrMissingHandler, letter.Listener, letter.EventType)
+	}
+
+	msg := reflect.New(msgType).Interface()
+	if err := json.Unmarshal([]byte(letter.Payload), msg); err != nil {
+		return err
+	}
+	headers := map[string]string{}
+	if letter.Headers != "" {
+		if err := json.Unmarshal([]byte(letter.Headers), &headers); err != nil {
+			return err
+		}
+	}
+	replayCtx := tracing.Extract(ctx, headers)
+	replay := withInvocation(replayCtx, KindPublish, msgType.String())
+	if err := instance.attempt(replay, msgType, target, msg); err != nil {
+		return err
+	}
+	return store.Remove(ctx, id)
+}
+
+func (c *deadLetterService) Init() error {
+	viper.SetDefault("bus.deadletter.sink", "log")
+	sink := viper.GetString("bus.deadletter.sink")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch sink {
+	case "log":
+		c.sink = LogSink{}
+	case "postgres":
+		c.sink = PostgresSink{}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownDeadLetterSink, sink)
+	}
+	return nil
+}
+
+func (c *deadLetterService) OnConfig() {
+}
+
+func (c *deadLetterService) store() (DeadLetterStore, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	store, ok := c.sink.(DeadLetterStore)
+	if !ok {
+		return nil, ErrDeadLettersNotStored
+	}
+	return store, nil
+}
+
+// deadLetter msg the listener l failed to handle with err
+func deadLetter(ctx context.Context, msgType reflect.Type, l *listener, msg Msg, err error) error {
+	payload, encodeErr := json.Marshal(msg)
+	if encodeErr != nil {
+		return encodeErr
+	}
+	headers, encodeErr := json.Marshal(tracing.Inject(ctx))
+	if encodeErr != nil {
+		return encodeErr
+	}
+	deadLetters.mu.RLock()
+	sink := deadLetters.sink
+	deadLetters.mu.RUnlock()
+	return sink.Put(ctx, &DeadLetter{
+		EventType: typeName(msgType),
+		Listener:  l.name,
+		Payload:   string(payload),
+		Headers:   string(headers),
+		Attempts:  l.policy.MaxAttempts,
+		Error:     err.Error(),
+		Created:   time.Now(),
+	})
+}
+
+func addDeadLetterMigrations() {
+	deadLetterV1 := postgres.Table{
+		Name: "dead_letter",
+		Columns: []*postgres.Column{
+			{Name: "id", Type: postgres.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "event_type", Type: postgres.DB_Varchar, Length: 255},
+			{Name: "listener", Type: postgres.DB_Varchar, Length: 255},
+			{Name: "payload", Type: postgres.DB_Text},
+			{Name: "headers", Type: postgres.DB_Text},
+			{Name: "attempts", Type: postgres.DB_Integer},
+			{Name: "error", Type: postgres.DB_Text},
+			{Name: "created", Type: postgres.DB_TimeStamp},
+		},
+	}
+	postgres.AddMigration("create dead_letter table", postgres.AddTable(deadLetterV1))
+}
@@ -0,0 +1,53 @@
+package bus
+
+import (
+	"errors"
+	"strings"
+)
+
+// MultiError aggregates the errors of every listener of a published event,
+// errors.Is and errors.As match any of them.
+type MultiError struct {
+	Errors []error
+}
+
+func (m *MultiError) Error() string {
+	messages := make([]string, len(m.Errors))
+	for i, err := range m.Errors {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (m *MultiError) Is(target error) bool {
+	for _, err := range m.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MultiError) As(target interface{}) bool {
+	for _, err := range m.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors returns nil when every error is nil, otherwise a MultiError of
+// the non nil errors.
+func joinErrors(errs ...error) error {
+	joined := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			joined = append(joined, err)
+		}
+	}
+	if len(joined) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: joined}
+}
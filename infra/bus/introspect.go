@@ -0,0 +1,56 @@
+package bus
+
+import "sort"
+
+// HandlerInfo describes a handler, listener or query handler registered on
+// the bus for introspection
+type HandlerInfo struct {
+	Kind     string
+	Message  string
+	Function string
+	Policy   *Policy
+}
+
+// Handlers registered on the bus sorted by message
+func Handlers() []HandlerInfo {
+	infos := instance.handlerInfos()
+	queries.mu.RLock()
+	for queryType, handlers := range queries.handlers {
+		for _, handler := range handlers {
+			infos = append(infos, HandlerInfo{Kind: "query", Message: queryType.String(), Function: handler.name})
+		}
+	}
+	queries.mu.RUnlock()
+	sort.SliceStable(infos, func(i, j int) bool {
+		if infos[i].Message != infos[j].Message {
+			return infos[i].Message < infos[j].Message
+		}
+		return infos[i].Kind < infos[j].Kind
+	})
+	return infos
+}
+
+func (bus *bus) handlerInfos() []HandlerInfo {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	infos := make([]HandlerInfo, 0)
+	for msgType, handler := range bus.handlers {
+		infos = append(infos, HandlerInfo{Kind: "handler", Message: msgType.String(), Function: handlerName(handler)})
+	}
+	for msgType, handler := range bus.handlersWithCtx {
+		infos = append(infos, HandlerInfo{Kind: "ctx_handler", Message: msgType.String(), Function: handlerName(handler)})
+	}
+	for msgType, listeners := range bus.listeners {
+		for _, l := range listeners {
+			policy := l.policy
+			infos = append(infos, HandlerInfo{Kind: "listener", Message: msgType.String(), Function: l.name, Policy: &policy})
+		}
+	}
+	for msgType, listeners := range bus.listenersWithCtx {
+		for _, l := range listeners {
+			policy := l.policy
+			infos = append(infos, HandlerInfo{Kind: "ctx_listener", Message: msgType.String(), Function: l.name, Policy: &policy})
+		}
+	}
+	return infos
+}
@@ -0,0 +1,148 @@
+package bus
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Policy of a listener for the events it fails to handle
+type Policy struct {
+	// MaxAttempts to handle an event, 1 does not retry
+	MaxAttempts int
+	// Backoff before the second attempt, doubled before every next one
+	Backoff time.Duration
+	// MaxBackoff between attempts, 0 does not bound the backoff
+	MaxBackoff time.Duration
+	// Timeout of every attempt, only listeners taking a context observe it
+	Timeout time.Duration
+	// DeadLetter the event once every attempt failed, the error is no longer
+	// returned to the publisher when the dead letter sink accepted it
+	DeadLetter bool
+}
+
+// ListenerOption sets the policy of a listener when it is added
+type ListenerOption func(policy *Policy)
+
+type listener struct {
+	name    string
+	handler HandlerFunc
+	withCtx bool
+	policy  Policy
+}
+
+// WithPolicy replaces the policy of the listener
+func WithPolicy(policy Policy) ListenerOption {
+	return func(p *Policy) {
+		*p = policy
+	}
+}
+
+// WithRetry attempts maxAttempts times, waiting backoff before the second
+// attempt and twice as long before every next one.
+func WithRetry(maxAttempts int, backoff time.Duration) ListenerOption {
+	return func(p *Policy) {
+		p.MaxAttempts = maxAttempts
+		p.Backoff = backoff
+	}
+}
+
+// WithAttemptTimeout bounds every attempt of a listener taking a context
+func WithAttemptTimeout(timeout time.Duration) ListenerOption {
+	return func(p *Policy) {
+		p.Timeout = timeout
+	}
+}
+
+// WithDeadLetter sends the events failing every attempt to the dead letter sink
+func WithDeadLetter() ListenerOption {
+	return func(p *Policy) {
+		p.DeadLetter = true
+	}
+}
+
+func newListener(handler HandlerFunc, withCtx bool, options []ListenerOption) *listener {
+	policy := Policy{MaxAttempts: 1}
+	for _, option := range options {
+		option(&policy)
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return &listener{
+		name:    handlerName(handler),
+		handler: handler,
+		withCtx: withCtx,
+		policy:  policy,
+	}
+}
+
+// handlerName of the func registered as handler or listener
+func handlerName(handler HandlerFunc) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return reflect.TypeOf(handler).String()
+}
+
+// deliver msg to the listener following its policy
+func (bus *bus) deliver(ctx context.Context, msgType reflect.Type, l *listener, msg Msg) error {
+	err := bus.attempt(ctx, msgType, l, msg)
+	if err == nil || !l.policy.DeadLetter {
+		return err
+	}
+	if sinkErr := deadLetter(ctx, msgType, l, msg, err); sinkErr != nil {
+		log.WithFields(log.Fields{
+			"Listener": l.name,
+			"Error":    sinkErr,
+		}).Error("Dead letter sink failed")
+		return err
+	}
+	return nil
+}
+
+// attempt to handle msg up to MaxAttempts, returns the last error
+func (bus *bus) attempt(ctx context.Context, msgType reflect.Type, l *listener, msg Msg) error {
+	invoke := bus.chain(msgType, l.handler, l.withCtx)
+	backoff := l.policy.Backoff
+	var err error
+	for attempt := 1; attempt <= l.policy.MaxAttempts; attempt++ {
+		if err = invokeAttempt(ctx, invoke, l.policy.Timeout, msg); err == nil {
+			return nil
+		}
+		if attempt == l.policy.MaxAttempts {
+			break
+		}
+		log.WithFields(log.Fields{
+			"Listener": l.name,
+			"Attempt":  attempt,
+			"Backoff":  backoff,
+			"Error":    err,
+		}).Warn("Bus listener failed, retrying")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		if l.policy.MaxBackoff > 0 && backoff > l.policy.MaxBackoff {
+			backoff = l.policy.MaxBackoff
+		}
+	}
+	return err
+}
+
+func invokeAttempt(ctx context.Context, invoke Handler, timeout time.Duration, msg Msg) error {
+	if timeout <= 0 {
+		return invoke(ctx, msg)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return invoke(ctx, msg)
+}
@@ -0,0 +1,114 @@
+package bus
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MemoryTransport is an in-process broker standing in for an external one,
+// envelopes are encoded and delivered asynchronously to every subscription of
+// their type as they would be over the network. A service skips the envelopes
+// it sent, the services sharing it are told apart by the source of their
+// bridge.
+type MemoryTransport struct {
+	mu            sync.RWMutex
+	subscriptions map[string][]*memorySubscription
+	closed        bool
+	waitGroup     sync.WaitGroup
+}
+
+type memorySubscription struct {
+	handler EnvelopeHandler
+	queue   chan []byte
+}
+
+// NewMemoryTransport returns an in-process transport, used for
+// bus.transport.driver memory.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		subscriptions: make(map[string][]*memorySubscription),
+	}
+}
+
+// Send the envelope to the subscriptions of its type, blocking while a
+// subscription is full until ctx is done.
+func (t *MemoryTransport) Send(ctx context.Context, envelope *Envelope) error {
+	data, err := EncodeEnvelope(envelope)
+	if err != nil {
+		return err
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	for _, subscription := range t.subscriptions[envelope.Type] {
+		select {
+		case subscription.queue <- data:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Subscribe handler to the envelopes of eventType
+func (t *MemoryTransport) Subscribe(eventType string, handler EnvelopeHandler) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	subscription := &memorySubscription{
+		handler: handler,
+		queue:   make(chan []byte, 1024),
+	}
+	t.subscriptions[eventType] = append(t.subscriptions[eventType], subscription)
+	t.waitGroup.Add(1)
+	go t.receive(eventType, subscription)
+	return nil
+}
+
+// Close the transport once the sent envelopes are consumed, or ctx is done.
+func (t *MemoryTransport) Close(ctx context.Context) error {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		for _, subscriptions := range t.subscriptions {
+			for _, subscription := range subscriptions {
+				close(subscription.queue)
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MemoryTransport) receive(eventType string, subscription *memorySubscription) {
+	defer t.waitGroup.Done()
+	for data := range subscription.queue {
+		envelope, err := DecodeEnvelope(data)
+		if err == nil {
+			err = subscription.handler(context.Background(), envelope)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"Event": eventType,
+				"Error": err,
+			}).Error("Bus transport consumer failed")
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"go-microservice/infra/metrics"
+	"go-microservice/infra/tracing"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Kind of the invocation a middleware wraps
+type Kind string
+
+const (
+	KindDispatch Kind = "dispatch"
+	KindPublish  Kind = "publish"
+	KindQuery    Kind = "query"
+)
+
+// Handler is the invocation of one handler or listener with msg
+type Handler func(ctx context.Context, msg Msg) error
+
+// Middleware wraps every invocation of the handlers and listeners, call next
+// to continue the invocation.
+type Middleware func(next Handler) Handler
+
+// Invocation describes the invocation in the ctx of a Handler
+type Invocation struct {
+	Kind    Kind
+	Message string
+}
+
+// Validator is implemented by messages checked by the Validation middleware
+type Validator interface {
+	Validate() error
+}
+
+type invocationKey struct{}
+
+func init() {
+	Use(Tracing, Metrics, Logging, Recover)
+}
+
+// Use global middleware wrapping every dispatch, publish and query, in the order
+// given and after the ones already in use. Tracing, Metrics, Logging and
+// Recover are in use by default.
+func Use(middleware ...Middleware) {
+	instance.use(nil, middleware...)
+}
+
+// UseFor middleware wrapping the invocations of the type of msg only,
+// it is called after the global middleware.
+func UseFor(msg Msg, middleware ...Middleware) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	instance.use(msgType, middleware...)
+	return nil
+}
+
+// InvocationFromContext returns the invocation a middleware is wrapping
+func InvocationFromContext(ctx context.Context) Invocation {
+	invocation, _ := ctx.Value(invocationKey{}).(Invocation)
+	return invocation
+}
+
+func (bus *bus) use(msgType reflect.Type, middleware ...Middleware) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if msgType == nil {
+		bus.middleware = append(bus.middleware, middleware...)
+		return
+	}
+	bus.typeMiddleware[msgType] = append(bus.typeMiddleware[msgType], middleware...)
+}
+
+// chain wraps the invocation of handler with the middleware
+func (bus *bus) chain(msgType reflect.Type, handler HandlerFunc, withCtx bool) Handler {
+	return bus.wrap(msgType, func(ctx context.Context, msg Msg) error {
+		var params = []reflect.Value{}
+		if withCtx {
+			params = append(params, reflect.ValueOf(ctx))
+		}
+		params = append(params, reflect.ValueOf(msg))
+		return call(handler, params)
+	})
+}
+
+// wrap next with the global then the message type middleware, the first one
+// in use is the outermost.
+func (bus *bus) wrap(msgType reflect.Type, next Handler) Handler {
+	bus.mu.RLock()
+	defer bus.mu.RUnlock()
+	typeMiddleware := bus.typeMiddleware[msgType]
+	for i := len(typeMiddleware) - 1; i >= 0; i-- {
+		next = typeMiddleware[i](next)
+	}
+	for i := len(bus.middleware) - 1; i >= 0; i-- {
+		next = bus.middleware[i](next)
+	}
+	return next
+}
+
+func withInvocation(ctx context.Context, kind Kind, msgName string) context.Context {
+	return context.WithValue(ctx, invocationKey{}, Invocation{Kind: kind, Message: msgName})
+}
+
+// Tracing records a span per invocation
+func Tracing(next Handler) Handler {
+	return func(ctx context.Context, msg Msg) error {
+		invocation := InvocationFromContext(ctx)
+		ctx, span := tracing.Start(ctx, "bus."+string(invocation.Kind)+" "+invocation.Message,
+			attribute.String("bus.message", invocation.Message))
+		err := next(ctx, msg)
+		tracing.End(span, err)
+		return err
+	}
+}
+
+// Metrics counts the invocations and their latency per message
+func Metrics(next Handler) Handler {
+	return func(ctx context.Context, msg Msg) error {
+		invocation := InvocationFromContext(ctx)
+		start := time.Now()
+		err := next(ctx, msg)
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		metrics.BusMessages.WithLabelValues(string(invocation.Kind), invocation.Message, status).Inc()
+		metrics.BusMessageSeconds.WithLabelValues(string(invocation.Kind), invocation.Message).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// Logging logs failed invocations and the latency of every invocation at
+// debug level
+func Logging(next Handler) Handler {
+	return func(ctx context.Context, msg Msg) error {
+		invocation := InvocationFromContext(ctx)
+		start := time.Now()
+		err := next(ctx, msg)
+		fields := log.Fields{
+			"Kind":     invocation.Kind,
+			"Message":  invocation.Message,
+			"Duration": time.Since(start),
+		}
+		if err != nil {
+			fields["Error"] = err
+			log.WithFields(fields).Error("Bus handler failed")
+			return err
+		}
+		log.WithFields(fields).Debug("Bus handler completed")
+		return nil
+	}
+}
+
+// Recover turns a panic of the handler into an error
+func Recover(next Handler) Handler {
+	return func(ctx context.Context, msg Msg) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				invocation := InvocationFromContext(ctx)
+				log.WithFields(log.Fields{
+					"Message": invocation.Message,
+					"Panic":   r,
+					"Stack":   string(debug.Stack()),
+				}).Error("Bus handler panicked")
+				err = fmt.Errorf("%w: %s panicked: %v", ErrHandlerPanic, invocation.Message, r)
+			}
+		}()
+		return next(ctx, msg)
+	}
+}
+
+// Validation rejects messages implementing Validator which fail to validate
+func Validation(next Handler) Handler {
+	return func(ctx context.Context, msg Msg) error {
+		if validator, ok := msg.(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+			}
+		}
+		return next(ctx, msg)
+	}
+}
+
+// Timeout bounds every invocation with a deadline
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, msg Msg) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
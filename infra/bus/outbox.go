@@ -0,0 +1,384 @@
+package bus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-microservice/infra/dbs/postgres"
+	"go-microservice/infra/server"
+	"go-microservice/infra/tracing"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	relay                 *outboxRelay
+	ErrMissingTx          = errors.New("Outbox needs a transaction")
+	ErrOutboxNotAbandoned = errors.New("Outbox event not abandoned")
+)
+
+// OutboxEvent is an event written by PublishTx, the row is deleted once
+// delivered to the local listeners and every broker. An event failing
+// bus.outbox.maxattempts times is kept with AbandonedAt set, until replayed
+// with ReplayOutboxEvent.
+type OutboxEvent struct {
+	Id          int64      `json:"id"`
+	EventType   string     `json:"event_type"`
+	Payload     string     `json:"payload"`
+	Headers     string     `json:"headers"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	AbandonedAt *time.Time `json:"abandoned_at"`
+	Created     time.Time  `json:"created"`
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox"
+}
+
+// Broker receives the outbox events along with the local listeners, an event
+// is retried until every broker accepted it.
+type Broker interface {
+	Publish(ctx context.Context, event *OutboxEvent) error
+}
+
+// outboxRelay delivers the events of the outbox table with at least once
+// semantics, an event failing to be delivered is retried with exponential
+// backoff.
+type outboxRelay struct {
+	mu          sync.RWMutex
+	brokers     []Broker
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	maxBackoff  time.Duration
+	timeout     time.Duration
+	lease       time.Duration
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+func init() {
+	relay = &outboxRelay{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	server.RegisterService(relay, server.Low, "postgres")
+	addOutboxMigrations()
+}
+
+// PublishTx writes the msg to the outbox in the transaction tx, it is
+// published to the listeners and brokers once tx is committed. Listeners may
+// get an event more than once and must be idempotent.
+func PublishTx(tx *gorm.DB, msg Msg) error {
+	if tx == nil {
+		return ErrMissingTx
+	}
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	headers, err := json.Marshal(tracing.Inject(postgres.Context(tx)))
+	if err != nil {
+		return err
+	}
+
+	if err := instance.registerEvent(msgType); err != nil {
+		return err
+	}
+	now := time.Now()
+	return tx.Create(&OutboxEvent{
+		EventType:   typeName(msgType),
+		Payload:     string(payload),
+		Headers:     string(headers),
+		NextAttempt: now,
+		Created:     now,
+	}).Error
+}
+
+// AddBroker forwards the outbox events to broker.
+func AddBroker(broker Broker) {
+	relay.mu.Lock()
+	defer relay.mu.Unlock()
+	relay.brokers = append(relay.brokers, broker)
+}
+
+// ListAbandonedEvents of the outbox, oldest first
+func ListAbandonedEvents(ctx context.Context, limit int, offset int) ([]*OutboxEvent, error) {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*OutboxEvent, 0)
+	err = db.Where("abandoned_at IS NOT NULL").Order("id").Offset(offset).Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// ReplayOutboxEvent relays the abandoned event again, with
+// bus.outbox.maxattempts new attempts.
+func ReplayOutboxEvent(ctx context.Context, id int64) error {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	query := db.Model(&OutboxEvent{}).Where("id = ? AND abandoned_at IS NOT NULL", id).Updates(map[string]interface{}{
+		"attempts":     0,
+		"next_attempt": time.Now(),
+		"abandoned_at": nil,
+	})
+	if query.Error != nil {
+		return query.Error
+	}
+	if query.RowsAffected == 0 {
+		return fmt.Errorf("%w: %d", ErrOutboxNotAbandoned, id)
+	}
+	return nil
+}
+
+func addOutboxMigrations() {
+	outboxV1 := postgres.Table{
+		Name: "outbox",
+		Columns: []*postgres.Column{
+			{Name: "id", Type: postgres.DB_BigInt, IsPrimaryKey: true, IsAutoIncrement: true},
+			{Name: "event_type", Type: postgres.DB_Varchar, Length: 255},
+			{Name: "payload", Type: postgres.DB_Text},
+			{Name: "headers", Type: postgres.DB_Text},
+			{Name: "attempts", Type: postgres.DB_Integer},
+			{Name: "last_error", Type: postgres.DB_Text, Nullable: true},
+			{Name: "next_attempt", Type: postgres.DB_TimeStamp},
+			{Name: "created", Type: postgres.DB_TimeStamp},
+		},
+	}
+	postgres.AddMigration("create outbox table", postgres.AddTable(outboxV1))
+	postgres.AddMigration("add outbox next_attempt index", postgres.AddIndex(outboxV1, &postgres.Index{
+		Name: "next_attempt", Cols: []string{"next_attempt"},
+	}))
+	postgres.AddMigration("add outbox abandoned_at column", postgres.AddColumn(outboxV1, &postgres.Column{
+		Name: "abandoned_at", Type: postgres.DB_TimeStamp, Nullable: true,
+	}))
+}
+
+func (c *outboxRelay) Init() error {
+	viper.SetDefault("bus.outbox.interval", "1s")
+	viper.SetDefault("bus.outbox.batchsize", 100)
+	viper.SetDefault("bus.outbox.maxattempts", 10)
+	viper.SetDefault("bus.outbox.maxbackoff", "5m")
+	viper.SetDefault("bus.outbox.timeout", "10s")
+	viper.SetDefault("bus.outbox.lease", "5m")
+	c.interval = viper.GetDuration("bus.outbox.interval")
+	c.batchSize = viper.GetInt("bus.outbox.batchsize")
+	c.maxAttempts = viper.GetInt("bus.outbox.maxattempts")
+	c.maxBackoff = viper.GetDuration("bus.outbox.maxbackoff")
+	c.timeout = viper.GetDuration("bus.outbox.timeout")
+	c.lease = viper.GetDuration("bus.outbox.lease")
+	if c.interval <= 0 {
+		c.interval = time.Second
+	}
+	if c.timeout <= 0 {
+		c.timeout = 10 * time.Second
+	}
+	if c.lease < c.timeout {
+		c.lease = c.timeout
+	}
+	return nil
+}
+
+func (c *outboxRelay) OnConfig() {
+}
+
+// Run relays the outbox once postgres is migrated, until stopped.
+func (c *outboxRelay) Run(ctx context.Context) error {
+	defer close(c.done)
+	waitCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+	err := postgres.WaitMigrated(waitCtx)
+	cancel()
+	if err != nil {
+		return nil
+	}
+	log.Info("Outbox relay started")
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			relayed, err := c.relayBatch(ctx)
+			if err != nil {
+				log.WithField("Error", err).Error("Outbox relay failed")
+			}
+			if err != nil || relayed < c.batchSize {
+				break
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-c.stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Stop relaying and wait for the batch being delivered.
+func (c *outboxRelay) Stop(ctx context.Context) error {
+	c.once.Do(func() { close(c.stop) })
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// relayBatch claims the due events then delivers them, the rows are locked
+// only while claimed so that the relays of other instances skip them without
+// a transaction held during the deliveries. A batch left undelivered, by a
+// relay stopped or slower than bus.outbox.lease, is relayed again once the
+// lease is over. Returns the events claimed.
+func (c *outboxRelay) relayBatch(ctx context.Context) (int, error) {
+	events, err := c.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	leaseCtx, cancel := context.WithTimeout(ctx, c.lease)
+	defer cancel()
+	for _, event := range events {
+		if leaseCtx.Err() != nil {
+			break
+		}
+		deliverCtx, cancel := context.WithTimeout(leaseCtx, c.timeout)
+		err := c.deliver(deliverCtx, event)
+		cancel()
+		if err != nil {
+			err = c.retry(ctx, event, err)
+		} else {
+			err = c.remove(ctx, event)
+		}
+		if err != nil {
+			return len(events), err
+		}
+	}
+	return len(events), nil
+}
+
+// claim the due events for bus.outbox.lease
+func (c *outboxRelay) claim(ctx context.Context) ([]*OutboxEvent, error) {
+	events := make([]*OutboxEvent, 0)
+	err := postgres.Transaction(ctx, func(tx *gorm.DB) error {
+		now := time.Now()
+		query := tx.Set("gorm:query_option", "FOR UPDATE SKIP LOCKED").
+			Where("next_attempt <= ? AND abandoned_at IS NULL", now).
+			Order("id").
+			Limit(c.batchSize)
+		if err := query.Find(&events).Error; err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		ids := make([]int64, len(events))
+		for i, event := range events {
+			ids[i] = event.Id
+		}
+		return tx.Model(&OutboxEvent{}).Where("id IN (?)", ids).Update("next_attempt", now.Add(c.lease)).Error
+	})
+	return events, err
+}
+
+// remove the delivered event
+func (c *outboxRelay) remove(ctx context.Context, event *OutboxEvent) error {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Delete(event).Error
+}
+
+// deliver the event to the local listeners and the brokers within ctx
+func (c *outboxRelay) deliver(ctx context.Context, event *OutboxEvent) error {
+	headers := map[string]string{}
+	if event.Headers != "" {
+		if err := json.Unmarshal([]byte(event.Headers), &headers); err != nil {
+			return err
+		}
+	}
+	ctx = tracing.Extract(ctx, headers)
+
+	errs := make([]error, 0)
+	if msgType, ok := instance.eventType(event.EventType); ok {
+		msg := reflect.New(msgType).Interface()
+		if err := json.Unmarshal([]byte(event.Payload), msg); err != nil {
+			return err
+		}
+		errs = append(errs, instance.publishCtx(ctx, msg))
+	}
+
+	c.mu.RLock()
+	brokers := c.brokers
+	c.mu.RUnlock()
+	for _, broker := range brokers {
+		errs = append(errs, broker.Publish(ctx, event))
+	}
+	return joinErrors(errs...)
+}
+
+// retry the event after a backoff doubling with every attempt, or abandon it
+// after bus.outbox.maxattempts.
+func (c *outboxRelay) retry(ctx context.Context, event *OutboxEvent, cause error) error {
+	event.Attempts++
+	updates := map[string]interface{}{
+		"attempts":     event.Attempts,
+		"last_error":   fmt.Sprint(cause),
+		"next_attempt": time.Now().Add(c.backoff(event.Attempts)),
+	}
+	fields := log.Fields{
+		"Id":       event.Id,
+		"Event":    event.EventType,
+		"Attempts": event.Attempts,
+		"Error":    cause,
+	}
+	if c.maxAttempts > 0 && event.Attempts >= c.maxAttempts {
+		updates["abandoned_at"] = time.Now()
+		log.WithFields(fields).Error("Outbox event abandoned after max attempts, replay it with bus.ReplayOutboxEvent")
+	} else {
+		log.WithFields(fields).Warn("Outbox event delivery failed, retrying")
+	}
+
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Model(event).Updates(updates).Error
+}
+
+// backoff before the next attempt, doubling from bus.outbox.interval up to
+// bus.outbox.maxbackoff. Without maximum, an overflowing backoff is the
+// interval.
+func (c *outboxRelay) backoff(attempts int) time.Duration {
+	backoff := c.interval << uint(attempts-1)
+	if c.maxBackoff > 0 && (backoff <= 0 || backoff > c.maxBackoff) {
+		return c.maxBackoff
+	}
+	if backoff <= 0 || backoff < c.interval {
+		return c.interval
+	}
+	return backoff
+}
@@ -0,0 +1,30 @@
+package bus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	c := &outboxRelay{interval: time.Second, maxBackoff: time.Minute}
+	expected := map[int]time.Duration{
+		1:   time.Second,
+		2:   2 * time.Second,
+		6:   32 * time.Second,
+		7:   time.Minute,
+		100: time.Minute,
+	}
+	for attempts, backoff := range expected {
+		if got := c.backoff(attempts); got != backoff {
+			t.Errorf("attempt %d: expected %s, got %s", attempts, backoff, got)
+		}
+	}
+
+	// without maximum an overflowing backoff does not retry right away
+	c.maxBackoff = 0
+	for _, attempts := range []int{35, 64, 100} {
+		if got := c.backoff(attempts); got < c.interval {
+			t.Errorf("attempt %d: backoff %s below the interval", attempts, got)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-microservice/infra/server"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	queries              *queryBus
+	ErrAmbiguousQuery    = errors.New("Query has more than one handler")
+	ErrInvalidResult     = errors.New("Invalid query result")
+	ErrQueryTimeout      = errors.New("Query timed out")
+	ErrInvalidResultType = errors.New("Query result type mismatch")
+)
+
+// queryBus routes queries to the handlers returning their result, a query
+// may have many handlers answering it together with QueryAll.
+type queryBus struct {
+	mu       sync.RWMutex
+	handlers map[reflect.Type][]*queryHandler
+	timeouts map[reflect.Type]time.Duration
+	timeout  time.Duration
+}
+
+type queryHandler struct {
+	name    string
+	handler HandlerFunc
+}
+
+func init() {
+	queries = &queryBus{
+		handlers: make(map[reflect.Type][]*queryHandler),
+		timeouts: make(map[reflect.Type]time.Duration),
+	}
+	server.RegisterService(queries, server.Low)
+}
+
+// AddQueryHandler registers func(context.Context, *Query) (Result, error) as
+// a handler of Query.
+func AddQueryHandler(handler HandlerFunc) error {
+	queryType, err := queryHandlerType(handler)
+	if err != nil {
+		return err
+	}
+	queries.mu.Lock()
+	defer queries.mu.Unlock()
+	queries.handlers[queryType] = append(queries.handlers[queryType], &queryHandler{
+		name:    handlerName(handler),
+		handler: handler,
+	})
+	return nil
+}
+
+// SetQueryTimeout of the queries of the type of query, overriding
+// bus.query.timeout.
+func SetQueryTimeout(query Msg, timeout time.Duration) error {
+	queryType, err := messageType(query)
+	if err != nil {
+		return err
+	}
+	queries.mu.Lock()
+	defer queries.mu.Unlock()
+	queries.timeouts[queryType] = timeout
+	return nil
+}
+
+// Query asks the only handler of query, its result is stored in the value
+// result points to.
+func Query(ctx context.Context, query Msg, result interface{}) error {
+	queryType, err := messageType(query)
+	if err != nil {
+		return err
+	}
+	resultValue := reflect.ValueOf(result)
+	if resultValue.Kind() != reflect.Ptr || resultValue.IsNil() {
+		return fmt.Errorf("%w: %T is not a non nil pointer", ErrInvalidResult, result)
+	}
+
+	handlers := queries.handlersOf(queryType)
+	switch {
+	case len(handlers) == 0:
+		return fmt.Errorf("%w: %s", ErrMissingHandler, queryType)
+	case len(handlers) > 1:
+		return fmt.Errorf("%w: %s", ErrAmbiguousQuery, queryType)
+	}
+
+	ctx, cancel := queries.withTimeout(ctx, queryType)
+	defer cancel()
+	answer, err := queries.ask(ctx, queryType, handlers[0], query)
+	if err != nil {
+		return err
+	}
+	return assign(resultValue.Elem(), answer)
+}
+
+// QueryAll asks every handler of query concurrently and appends their results
+// to the slice results points to, a handler returning a slice of the element
+// type has its elements appended. The results of the handlers answering in
+// time are kept when others fail, their errors are returned as a MultiError.
+func QueryAll(ctx context.Context, query Msg, results interface{}) error {
+	queryType, err := messageType(query)
+	if err != nil {
+		return err
+	}
+	resultsValue := reflect.ValueOf(results)
+	if resultsValue.Kind() != reflect.Ptr || resultsValue.IsNil() || resultsValue.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("%w: %T is not a pointer to a slice", ErrInvalidResult, results)
+	}
+
+	handlers := queries.handlersOf(queryType)
+	if len(handlers) == 0 {
+		return fmt.Errorf("%w: %s", ErrMissingHandler, queryType)
+	}
+
+	ctx, cancel := queries.withTimeout(ctx, queryType)
+	defer cancel()
+	answers := make([]interface{}, len(handlers))
+	errs := make([]error, len(handlers))
+	var waitGroup sync.WaitGroup
+	for i, handler := range handlers {
+		waitGroup.Add(1)
+		go func(i int, handler *queryHandler) {
+			defer waitGroup.Done()
+			answers[i], errs[i] = queries.ask(ctx, queryType, handler, query)
+		}(i, handler)
+	}
+	waitGroup.Wait()
+
+	slice := resultsValue.Elem()
+	for i, answer := range answers {
+		if errs[i] != nil {
+			continue
+		}
+		merged, err := appendAnswer(slice, answer)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		slice = merged
+	}
+	resultsValue.Elem().Set(slice)
+	return joinErrors(errs...)
+}
+
+func (c *queryBus) Init() error {
+	viper.SetDefault("bus.query.timeout", "5s")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = viper.GetDuration("bus.query.timeout")
+	return nil
+}
+
+func (c *queryBus) OnConfig() {
+}
+
+func (c *queryBus) handlersOf(queryType reflect.Type) []*queryHandler {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.handlers[queryType]
+}
+
+// withTimeout bounds ctx by the timeout of the query type
+func (c *queryBus) withTimeout(ctx context.Context, queryType reflect.Type) (context.Context, context.CancelFunc) {
+	c.mu.RLock()
+	timeout, ok := c.timeouts[queryType]
+	if !ok {
+		timeout = c.timeout
+	}
+	c.mu.RUnlock()
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+type reply struct {
+	answer interface{}
+	err    error
+}
+
+// ask the handler through the middleware, returns its result or gives up on
+// it once ctx is done.
+func (c *queryBus) ask(ctx context.Context, queryType reflect.Type, handler *queryHandler, query Msg) (interface{}, error) {
+	replies := make(chan reply, 1)
+	go func() {
+		var answer interface{}
+		invoke := instance.wrap(queryType, func(ctx context.Context, msg Msg) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			ret := reflect.ValueOf(handler.handler).Call([]reflect.Value{reflect.ValueOf(ctx), reflect.ValueOf(msg)})
+			if err, _ := ret[1].Interface().(error); err != nil {
+				return err
+			}
+			answer = ret[0].Interface()
+			return nil
+		})
+		err := invoke(withInvocation(ctx, KindQuery, queryType.String()), query)
+		replies <- reply{answer: answer, err: err}
+	}()
+
+	var answer reply
+	select {
+	case answer = <-replies:
+	case <-ctx.Done():
+		answer.err = ctx.Err()
+	}
+	if errors.Is(answer.err, context.DeadlineExceeded) {
+		answer.err = fmt.Errorf("%w: %s by %s", ErrQueryTimeout, queryType, handler.name)
+	}
+	return answer.answer, answer.err
+}
+
+// assign the answer of a handler to the result
+func assign(result reflect.Value, answer interface{}) error {
+	if answer == nil {
+		result.Set(reflect.Zero(result.Type()))
+		return nil
+	}
+	answerValue := reflect.ValueOf(answer)
+	if !answerValue.Type().AssignableTo(result.Type()) {
+		return fmt.Errorf("%w: %s is not assignable to %s", ErrInvalidResultType, answerValue.Type(), result.Type())
+	}
+	result.Set(answerValue)
+	return nil
+}
+
+// appendAnswer of a handler to the results slice
+func appendAnswer(slice reflect.Value, answer interface{}) (reflect.Value, error) {
+	if answer == nil {
+		return slice, nil
+	}
+	answerValue := reflect.ValueOf(answer)
+	elemType := slice.Type().Elem()
+	if answerValue.Type().AssignableTo(elemType) {
+		return reflect.Append(slice, answerValue), nil
+	}
+	if answerValue.Kind() == reflect.Slice && answerValue.Type().Elem().AssignableTo(elemType) {
+		return reflect.AppendSlice(slice, answerValue), nil
+	}
+	return slice, fmt.Errorf("%w: %s is not assignable to %s", ErrInvalidResultType, answerValue.Type(), elemType)
+}
+
+// queryHandlerType validates the handler is
+// func(context.Context, *Query) (Result, error) and returns the type of Query.
+func queryHandlerType(handler HandlerFunc) (reflect.Type, error) {
+	handlerType := reflect.TypeOf(handler)
+	signature := "func(context.Context, *Query) (Result, error)"
+	if handlerType == nil || handlerType.Kind() != reflect.Func || reflect.ValueOf(handler).IsNil() {
+		return nil, fmt.Errorf("%w: %T is not a %s", ErrInvalidHandler, handler, signature)
+	}
+	if handlerType.NumIn() != 2 || handlerType.IsVariadic() || handlerType.In(0) != contextType ||
+		handlerType.In(1).Kind() != reflect.Ptr ||
+		handlerType.NumOut() != 2 || handlerType.Out(1) != errorType {
+		return nil, fmt.Errorf("%w: %s is not a %s", ErrInvalidHandler, handlerType, signature)
+	}
+	return handlerType.In(1).Elem(), nil
+}
@@ -0,0 +1,303 @@
+package bus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-microservice/infra/server"
+	"go-microservice/infra/tracing"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+var (
+	bridge                *transportBridge
+	ErrUnknownTransport   = errors.New("Unknown bus transport")
+	ErrTransportClosed    = errors.New("Bus transport is closed")
+	ErrUnknownContentType = errors.New("Unknown envelope content type")
+)
+
+// Envelope carries an event to and from the other services, the payload is
+// the event encoded as protobuf when it is a proto.Message, as JSON otherwise.
+type Envelope struct {
+	Id          string            `json:"id"`
+	Type        string            `json:"type"`
+	Source      string            `json:"source"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Headers     map[string]string `json:"headers"`
+	ContentType string            `json:"content_type"`
+	Payload     []byte            `json:"payload"`
+}
+
+// EnvelopeHandler consumes the envelopes of a subscription
+type EnvelopeHandler func(ctx context.Context, envelope *Envelope) error
+
+// Transport to an external broker, envelopes are sent and subscribed to by
+// event type.
+type Transport interface {
+	Send(ctx context.Context, envelope *Envelope) error
+	Subscribe(eventType string, handler EnvelopeHandler) error
+	Close(ctx context.Context) error
+}
+
+// TransportFactory creates the transport named in bus.transport.driver
+type TransportFactory func() (Transport, error)
+
+// NamedMsg events are sent under EventName() instead of their Go type name,
+// so that services agree on the name whatever their package is.
+type NamedMsg interface {
+	EventName() string
+}
+
+type remoteKey struct{}
+
+// transportBridge forwards the published events of the types in forwarded to
+// the transport, and publishes the envelopes consumed from it to the local
+// listeners.
+type transportBridge struct {
+	mu         sync.RWMutex
+	source     string
+	factories  map[string]TransportFactory
+	transport  Transport
+	forwarded  map[reflect.Type]bool
+	consumed   map[string]reflect.Type
+	subscribed map[string]bool
+}
+
+func init() {
+	bridge = newTransportBridge()
+	RegisterTransport("memory", func() (Transport, error) {
+		return NewMemoryTransport(), nil
+	})
+	server.RegisterService(bridge, server.Low, "tracing")
+}
+
+// newTransportBridge of a service, identified on the transport by a random
+// source.
+func newTransportBridge() *transportBridge {
+	return &transportBridge{
+		source:     newId(),
+		factories:  make(map[string]TransportFactory),
+		forwarded:  make(map[reflect.Type]bool),
+		consumed:   make(map[string]reflect.Type),
+		subscribed: make(map[string]bool),
+	}
+}
+
+// RegisterTransport makes a transport available as bus.transport.driver name
+func RegisterTransport(name string, factory TransportFactory) {
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	bridge.factories[name] = factory
+}
+
+// Forward the events of the type of msg published on the bus to the transport
+func Forward(msg Msg) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	bridge.forwarded[msgType] = true
+	return nil
+}
+
+// Consume the events of the type of msg sent by other services, they are
+// published to the local listeners.
+func Consume(msg Msg) error {
+	msgType, err := messageType(msg)
+	if err != nil {
+		return err
+	}
+	bridge.mu.Lock()
+	defer bridge.mu.Unlock()
+	name := eventName(msgType)
+	if consumed, ok := bridge.consumed[name]; ok && consumed != msgType {
+		return fmt.Errorf("%w: %s is consumed as %s", ErrDuplicateEvent, name, consumed)
+	}
+	bridge.consumed[name] = msgType
+	if bridge.transport == nil {
+		return nil
+	}
+	return bridge.subscribe(name)
+}
+
+func (c *transportBridge) Init() error {
+	viper.SetDefault("bus.transport.driver", "none")
+	driver := viper.GetString("bus.transport.driver")
+	if driver == "none" || driver == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	factory, ok := c.factories[driver]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTransport, driver)
+	}
+	transport, err := factory()
+	if err != nil {
+		return err
+	}
+	c.transport = transport
+	for name := range c.consumed {
+		if err := c.subscribe(name); err != nil {
+			return err
+		}
+	}
+	log.WithField("Driver", driver).Info("Bus transport connected")
+	return nil
+}
+
+func (c *transportBridge) OnConfig() {
+}
+
+// Stop closes the transport, events are no longer forwarded nor consumed.
+func (c *transportBridge) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	transport := c.transport
+	c.transport = nil
+	c.mu.Unlock()
+	if transport == nil {
+		return nil
+	}
+	return transport.Close(ctx)
+}
+
+// subscribe to the events named name, called with mu held
+func (c *transportBridge) subscribe(name string) error {
+	if c.subscribed[name] {
+		return nil
+	}
+	if err := c.transport.Subscribe(name, c.consume); err != nil {
+		return err
+	}
+	c.subscribed[name] = true
+	return nil
+}
+
+// forward msg when its type is forwarded, unless it was consumed from the
+// transport.
+func (c *transportBridge) forward(ctx context.Context, msgType reflect.Type, msg Msg) error {
+	if remote, _ := ctx.Value(remoteKey{}).(bool); remote {
+		return nil
+	}
+	c.mu.RLock()
+	transport := c.transport
+	forwarded := c.forwarded[msgType]
+	c.mu.RUnlock()
+	if !forwarded || transport == nil {
+		return nil
+	}
+
+	envelope, err := c.seal(ctx, msgType, msg)
+	if err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "bus.forward "+envelope.Type,
+		attribute.String("bus.message", envelope.Type),
+		attribute.String("bus.envelope", envelope.Id))
+	err = transport.Send(ctx, envelope)
+	tracing.End(span, err)
+	return err
+}
+
+// seal msg in an envelope carrying the trace context of ctx
+func (c *transportBridge) seal(ctx context.Context, msgType reflect.Type, msg Msg) (*Envelope, error) {
+	envelope := &Envelope{
+		Id:        newId(),
+		Type:      eventName(msgType),
+		Source:    c.source,
+		Timestamp: time.Now().UTC(),
+		Headers:   tracing.Inject(ctx),
+	}
+	var err error
+	if message, ok := msg.(proto.Message); ok {
+		envelope.ContentType = ContentTypeProtobuf
+		envelope.Payload, err = proto.Marshal(message)
+	} else {
+		envelope.ContentType = ContentTypeJSON
+		envelope.Payload, err = json.Marshal(msg)
+	}
+	return envelope, err
+}
+
+// consume an envelope sent by another service, the ones this service sent
+// are skipped as its listeners already got them.
+func (c *transportBridge) consume(ctx context.Context, envelope *Envelope) error {
+	if envelope.Source == c.source {
+		return nil
+	}
+	c.mu.RLock()
+	msgType, ok := c.consumed[envelope.Type]
+	c.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	msg := reflect.New(msgType).Interface()
+	switch envelope.ContentType {
+	case ContentTypeProtobuf:
+		message, ok := msg.(proto.Message)
+		if !ok {
+			return fmt.Errorf("%w: %s is not a proto.Message", ErrUnknownContentType, msgType)
+		}
+		if err := proto.Unmarshal(envelope.Payload, message); err != nil {
+			return err
+		}
+	case ContentTypeJSON, "":
+		if err := json.Unmarshal(envelope.Payload, msg); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownContentType, envelope.ContentType)
+	}
+
+	ctx = tracing.Extract(ctx, envelope.Headers)
+	return instance.publishCtx(context.WithValue(ctx, remoteKey{}, true), msg)
+}
+
+// eventName of the events of msgType on the transport
+func eventName(msgType reflect.Type) string {
+	if named, ok := reflect.New(msgType).Interface().(NamedMsg); ok {
+		return named.EventName()
+	}
+	return typeName(msgType)
+}
+
+// newId returns a random UUID
+func newId() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// EncodeEnvelope for transports carrying bytes
+func EncodeEnvelope(envelope *Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+// DecodeEnvelope encoded by EncodeEnvelope
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	envelope := &Envelope{}
+	if err := json.Unmarshal(data, envelope); err != nil {
+		return nil, err
+	}
+	return envelope, nil
+}
@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type orderShipped struct {
+	Id string
+}
+
+type namedShipped struct{}
+
+func (namedShipped) EventName() string { return "orders.shipped" }
+
+type otherNamedShipped struct{}
+
+func (otherNamedShipped) EventName() string { return "orders.shipped" }
+
+// connect a bridge of its own source to transport, forwarding and consuming
+// the events of msgTypes
+func connect(t *testing.T, transport Transport, msgTypes ...reflect.Type) *transportBridge {
+	b := newTransportBridge()
+	b.transport = transport
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, msgType := range msgTypes {
+		b.forwarded[msgType] = true
+		b.consumed[eventName(msgType)] = msgType
+		if err := b.subscribe(eventName(msgType)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func TestTransportRoundTrip(t *testing.T) {
+	useBus(t)
+	transport := NewMemoryTransport()
+	msgType := reflect.TypeOf(orderShipped{})
+
+	// this service and another one on the same broker
+	previous := bridge
+	bridge = connect(t, transport, msgType)
+	t.Cleanup(func() { bridge = previous })
+	connect(t, transport, msgType)
+	t.Cleanup(func() { transport.Close(context.Background()) })
+
+	local := make(chan *orderShipped, 2)
+	remote := make(chan *orderShipped, 2)
+	if err := AddEventListenerCtx(func(ctx context.Context, event *orderShipped) error {
+		if isRemote, _ := ctx.Value(remoteKey{}).(bool); isRemote {
+			remote <- event
+		} else {
+			local <- event
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Publish(&orderShipped{Id: "42"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-local:
+		if event.Id != "42" {
+			t.Fatalf("local listener got %+v", event)
+		}
+	default:
+		t.Fatal("local listener not called")
+	}
+
+	// forwarded, consumed by the other service into the listeners once, and
+	// not forwarded back nor consumed by the sender
+	select {
+	case event := <-remote:
+		if event.Id != "42" {
+			t.Fatalf("consumed %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event not consumed from the transport")
+	}
+	select {
+	case event := <-remote:
+		t.Fatalf("event consumed twice: %+v", event)
+	case event := <-local:
+		t.Fatalf("consumed event published as local: %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTransportSkipsOwnEnvelopes(t *testing.T) {
+	useBus(t)
+	transport := NewMemoryTransport()
+	t.Cleanup(func() { transport.Close(context.Background()) })
+	msgType := reflect.TypeOf(orderShipped{})
+	sender := connect(t, transport, msgType)
+
+	consumed := make(chan struct{}, 1)
+	if err := AddEventListener(func(event *orderShipped) error {
+		consumed <- struct{}{}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sender.forward(context.Background(), msgType, &orderShipped{Id: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-consumed:
+		t.Fatal("the sender consumed its own envelope")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConsumeDuplicateEventName(t *testing.T) {
+	previous := bridge
+	bridge = newTransportBridge()
+	t.Cleanup(func() { bridge = previous })
+
+	if err := Consume(&namedShipped{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Consume(&namedShipped{}); err != nil {
+		t.Fatalf("consuming a type again failed: %v", err)
+	}
+	if err := Consume(&otherNamedShipped{}); !errors.Is(err, ErrDuplicateEvent) {
+		t.Fatalf("expected ErrDuplicateEvent, got %v", err)
+	}
+}
+
+func TestMemoryTransportClosed(t *testing.T) {
+	transport := NewMemoryTransport()
+	if err := transport.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Send(context.Background(), &Envelope{Type: "orders.shipped"}); err != ErrTransportClosed {
+		t.Fatalf("expected ErrTransportClosed, got %v", err)
+	}
+	if err := transport.Subscribe("orders.shipped", nil); err != ErrTransportClosed {
+		t.Fatalf("expected ErrTransportClosed, got %v", err)
+	}
+}
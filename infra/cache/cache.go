@@ -6,8 +6,6 @@ import (
 )
 
 var (
-	internalCache   Cache
-	remoteCache     Cache
 	ErrCacheMiss    = errors.New("key not found")
 	ErrNotStored    = errors.New("not stored")
 	ErrInvalidValue = errors.New("invalid value")
@@ -34,81 +32,96 @@ type Cache interface {
 	Flush() error
 }
 
+// getCache selected by cache.remote or cache.local
+func getCache(remote bool) Cache {
+	c := localCache()
+	if remote {
+		c = remoteCache()
+	}
+	if c == nil {
+		return noCache{}
+	}
+	return c
+}
+
 // Get the Content associated with key from the cache.
 // Returns an  error if not found
 func Get(remote bool, key string, ptrValue interface{}) error {
-	if remote {
-		return remoteCache.Get(key, ptrValue)
-	}
-	return internalCache.Get(key, ptrValue)
+	c := getCache(remote)
+	err := c.Get(key, ptrValue)
+	observe(c, "get", err)
+	return err
 }
 
 // Get the content associated multiple keys at once.  On success, the caller
 // may decode the values one at a time from the returned Getter.
 func GetMulti(remote bool, keys ...string) (Getter, error) {
-	if remote {
-		return remoteCache.GetMulti(keys...)
+	c := getCache(remote)
+	getter, err := c.GetMulti(keys...)
+	observe(c, "get_multi", err)
+	if err != nil {
+		return nil, err
 	}
-	return internalCache.GetMulti(keys...)
+	return &observedGetter{Getter: getter, cache: c}, nil
 }
 
 // Delete the given key from the cache.
 func Delete(remote bool, key string) error {
-	if remote {
-		return remoteCache.Delete(key)
-	}
-	return internalCache.Delete(key)
+	c := getCache(remote)
+	err := c.Delete(key)
+	observe(c, "delete", err)
+	return err
 }
 
 // Increment the value stored at the given key by the given amount.
 // The value silently wraps around upon exceeding the uint64 range.
 func Increment(remote bool, key string, n uint64) (newValue uint64, err error) {
-	if remote {
-		return remoteCache.Increment(key, n)
-	}
-	return internalCache.Increment(key, n)
+	c := getCache(remote)
+	newValue, err = c.Increment(key, n)
+	observe(c, "increment", err)
+	return newValue, err
 }
 
 // Decrement the value stored at the given key by the given amount.
 // The value is capped at 0 on underflow, with no error returned.
 func Decrement(remote bool, key string, n uint64) (newValue uint64, err error) {
-	if remote {
-		return remoteCache.Decrement(key, n)
-	}
-	return internalCache.Decrement(key, n)
+	c := getCache(remote)
+	newValue, err = c.Decrement(key, n)
+	observe(c, "decrement", err)
+	return newValue, err
 }
 
 // Expire all cache entries immediately.
 // This is not implemented for the memcached cache (intentionally).
 // Returns an implementation specific error if the operation failed.
 func Flush(remote bool) error {
-	if remote {
-		return remoteCache.Flush()
-	}
-	return internalCache.Flush()
+	c := getCache(remote)
+	err := c.Flush()
+	observe(c, "flush", err)
+	return err
 }
 
 // Set the given key/value in the cache, overwriting any existing value
 // associated with that key.  Keys may be at most 250 bytes in length.
 func Set(remote bool, key string, value interface{}, expires time.Duration) error {
-	if remote {
-		return remoteCache.Set(key, value, expires)
-	}
-	return internalCache.Set(key, value, expires)
+	c := getCache(remote)
+	err := c.Set(key, value, expires)
+	observe(c, "set", err)
+	return err
 }
 
 // Add the given key/value to the cache ONLY IF the key does not already exist.
 func Add(remote bool, key string, value interface{}, expires time.Duration) error {
-	if remote {
-		return remoteCache.Add(key, value, expires)
-	}
-	return internalCache.Add(key, value, expires)
+	c := getCache(remote)
+	err := c.Add(key, value, expires)
+	observe(c, "add", err)
+	return err
 }
 
 // Set the given key/value in the cache ONLY IF the key already exists.
 func Replace(remote bool, key string, value interface{}, expires time.Duration) error {
-	if remote {
-		return remoteCache.Replace(key, value, expires)
-	}
-	return internalCache.Replace(key, value, expires)
+	c := getCache(remote)
+	err := c.Replace(key, value, expires)
+	observe(c, "replace", err)
+	return err
 }
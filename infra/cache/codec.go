@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+)
+
+// The values encoded by a codec are stored after an envelope header of
+// envelopeHeader bytes: envelopeMagic, envelopeVersion, the codec id, the
+// flags and the big endian schema version of the value. Integers and []byte
+// are stored as is, integers in decimal for the remote increments.
+const (
+	envelopeMagic   = 0xCE
+	envelopeVersion = 1
+	envelopeHeader  = 8
+	flagGzip        = 1 << 0
+)
+
+// Codec encodes the values stored in the remote caches
+type Codec interface {
+	// Name of the codec in cache.codec
+	Name() string
+	// ID of the codec recorded in the envelope, the values are decoded with
+	// the codec they were encoded with whatever the codec of the cache.
+	ID() byte
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, ptrValue interface{}) error
+}
+
+// Versioned values record their SchemaVersion in the envelope, a value stored
+// with another version is not decoded into it, ErrSchemaVersion is returned.
+// Implement it on the value receiver so that both T and *T are versioned.
+type Versioned interface {
+	SchemaVersion() uint32
+}
+
+var (
+	Gob      Codec = gobCodec{}
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+
+	ErrUnknownCodec  = errors.New("unknown codec")
+	ErrSchemaVersion = errors.New("schema version mismatch")
+	ErrNotProtobuf   = errors.New("not a protobuf message")
+
+	codecsMu   sync.RWMutex
+	codecs     = make(map[byte]Codec)
+	codecNames = make(map[string]Codec)
+)
+
+// codecValue is a value encoded with another codec than the cache's
+type codecValue struct {
+	codec Codec
+	value interface{}
+}
+
+func init() {
+	viper.SetDefault("cache.codec", Gob.Name())
+	viper.SetDefault("cache.compressabove", 0)
+	RegisterCodec(Gob)
+	RegisterCodec(JSON)
+	RegisterCodec(Protobuf)
+}
+
+// RegisterCodec makes a codec available as cache.codec and to decode the
+// values encoded with it.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[codec.ID()] = codec
+	codecNames[codec.Name()] = codec
+}
+
+// WithCodec stores value encoded with codec instead of the codec of the cache
+func WithCodec(codec Codec, value interface{}) interface{} {
+	return &codecValue{codec: codec, value: value}
+}
+
+// codecNamed name in cache.codec
+func codecNamed(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecNames[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+	}
+	return codec, nil
+}
+
+// defaultCodec is the codec of cache.codec, gob when unknown
+func defaultCodec() Codec {
+	codec, err := codecNamed(viper.GetString("cache.codec"))
+	if err != nil {
+		return Gob
+	}
+	return codec
+}
+
+// compressAbove is the size from which the encoded values are compressed,
+// 0 never compresses.
+func compressAbove() int {
+	return viper.GetInt("cache.compressabove")
+}
+
+// plainValue of a value given WithCodec, for the caches storing values as is
+func plainValue(value interface{}) interface{} {
+	if v, ok := value.(*codecValue); ok {
+		return v.value
+	}
+	return value
+}
+
+// encode value with codec in an envelope
+func encode(codec Codec, value interface{}) ([]byte, error) {
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var flags byte
+	if threshold := compressAbove(); threshold > 0 && len(data) > threshold {
+		if data, err = compress(data); err != nil {
+			return nil, err
+		}
+		flags |= flagGzip
+	}
+	var version uint32
+	if versioned, ok := value.(Versioned); ok {
+		version = versioned.SchemaVersion()
+	}
+
+	envelope := make([]byte, envelopeHeader, envelopeHeader+len(data))
+	envelope[0] = envelopeMagic
+	envelope[1] = envelopeVersion
+	envelope[2] = codec.ID()
+	envelope[3] = flags
+	binary.BigEndian.PutUint32(envelope[4:], version)
+	return append(envelope, data...), nil
+}
+
+// isEnvelope tells whether data was encoded by encode, the values stored
+// before are gob streams which never start with envelopeMagic.
+func isEnvelope(data []byte) bool {
+	return len(data) >= envelopeHeader && data[0] == envelopeMagic && data[1] == envelopeVersion
+}
+
+// decode the envelope with the codec it records
+func decode(data []byte, ptrValue interface{}) error {
+	codecsMu.RLock()
+	codec, ok := codecs[data[2]]
+	codecsMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: id %d", ErrUnknownCodec, data[2])
+	}
+	if versioned, ok := ptrValue.(Versioned); ok {
+		stored := binary.BigEndian.Uint32(data[4:envelopeHeader])
+		if current := versioned.SchemaVersion(); stored != current {
+			return fmt.Errorf("%w: stored %d, expected %d", ErrSchemaVersion, stored, current)
+		}
+	}
+	payload := data[envelopeHeader:]
+	if data[3]&flagGzip != 0 {
+		var err error
+		if payload, err = decompress(payload); err != nil {
+			return err
+		}
+	}
+	return codec.Unmarshal(payload, ptrValue)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	writer := gzip.NewWriter(&b)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+	return ioutil.ReadAll(reader)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) ID() byte {
+	return 1
+}
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(value); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(ptrValue)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) ID() byte {
+	return 2
+}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	return json.Unmarshal(data, ptrValue)
+}
+
+// protobufCodec encodes the messages of generated/proto
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return "protobuf"
+}
+
+func (protobufCodec) ID() byte {
+	return 3
+}
+
+func (protobufCodec) Marshal(value interface{}) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtobuf, value)
+	}
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, ptrValue interface{}) error {
+	message, ok := ptrValue.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtobuf, ptrValue)
+	}
+	return proto.Unmarshal(data, message)
+}
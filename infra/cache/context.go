@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// ContextCache is implemented by the backends honoring the deadline of ctx
+// on their I/O, redis and memcache. The done ctx is checked before calling
+// the other backends only.
+type ContextCache interface {
+	GetCtx(ctx context.Context, key string, ptrValue interface{}) error
+	GetMultiCtx(ctx context.Context, keys ...string) (Getter, error)
+	SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error
+	AddCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error
+	ReplaceCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error
+	DeleteCtx(ctx context.Context, key string) error
+	IncrementCtx(ctx context.Context, key string, n uint64) (newValue uint64, err error)
+	DecrementCtx(ctx context.Context, key string, n uint64) (newValue uint64, err error)
+	FlushCtx(ctx context.Context) error
+}
+
+// GetCtx is Get traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func GetCtx(ctx context.Context, remote bool, key string, ptrValue interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "get", key)
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.GetCtx(ctx, key, ptrValue)
+	} else {
+		err = c.Get(key, ptrValue)
+	}
+	observe(c, "get", err)
+	endSpan(span, err)
+	return err
+}
+
+// GetMultiCtx is GetMulti traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func GetMultiCtx(ctx context.Context, remote bool, keys ...string) (Getter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	span := startSpan(ctx, remote, "get_multi", keys...)
+	c := getCache(remote)
+	var getter Getter
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		getter, err = cc.GetMultiCtx(ctx, keys...)
+	} else {
+		getter, err = c.GetMulti(keys...)
+	}
+	observe(c, "get_multi", err)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &observedGetter{Getter: getter, cache: c}, nil
+}
+
+// DeleteCtx is Delete traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func DeleteCtx(ctx context.Context, remote bool, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "delete", key)
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.DeleteCtx(ctx, key)
+	} else {
+		err = c.Delete(key)
+	}
+	observe(c, "delete", err)
+	endSpan(span, err)
+	return err
+}
+
+// IncrementCtx is Increment traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func IncrementCtx(ctx context.Context, remote bool, key string, n uint64) (newValue uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	span := startSpan(ctx, remote, "increment", key)
+	c := getCache(remote)
+	if cc, ok := c.(ContextCache); ok {
+		newValue, err = cc.IncrementCtx(ctx, key, n)
+	} else {
+		newValue, err = c.Increment(key, n)
+	}
+	observe(c, "increment", err)
+	endSpan(span, err)
+	return newValue, err
+}
+
+// DecrementCtx is Decrement traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func DecrementCtx(ctx context.Context, remote bool, key string, n uint64) (newValue uint64, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	span := startSpan(ctx, remote, "decrement", key)
+	c := getCache(remote)
+	if cc, ok := c.(ContextCache); ok {
+		newValue, err = cc.DecrementCtx(ctx, key, n)
+	} else {
+		newValue, err = c.Decrement(key, n)
+	}
+	observe(c, "decrement", err)
+	endSpan(span, err)
+	return newValue, err
+}
+
+// FlushCtx is Flush traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func FlushCtx(ctx context.Context, remote bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "flush")
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.FlushCtx(ctx)
+	} else {
+		err = c.Flush()
+	}
+	observe(c, "flush", err)
+	endSpan(span, err)
+	return err
+}
+
+// SetCtx is Set traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func SetCtx(ctx context.Context, remote bool, key string, value interface{}, expires time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "set", key)
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.SetCtx(ctx, key, value, expires)
+	} else {
+		err = c.Set(key, value, expires)
+	}
+	observe(c, "set", err)
+	endSpan(span, err)
+	return err
+}
+
+// AddCtx is Add traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func AddCtx(ctx context.Context, remote bool, key string, value interface{}, expires time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "add", key)
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.AddCtx(ctx, key, value, expires)
+	} else {
+		err = c.Add(key, value, expires)
+	}
+	observe(c, "add", err)
+	endSpan(span, err)
+	return err
+}
+
+// ReplaceCtx is Replace traced as part of the request in ctx,
+// returns the ctx error once ctx is done.
+func ReplaceCtx(ctx context.Context, remote bool, key string, value interface{}, expires time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	span := startSpan(ctx, remote, "replace", key)
+	c := getCache(remote)
+	var err error
+	if cc, ok := c.(ContextCache); ok {
+		err = cc.ReplaceCtx(ctx, key, value, expires)
+	} else {
+		err = c.Replace(key, value, expires)
+	}
+	observe(c, "replace", err)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"fmt"
+	"go-microservice/infra/metrics"
+	"reflect"
+	"sync"
+)
+
+// EvictionReason tells why an entry left the in-memory cache
+type EvictionReason int
+
+const (
+	// EvictedExpired entries outlived their expiry
+	EvictedExpired EvictionReason = iota
+	// EvictedCapacity entries made room within cache.memory.maxentries or
+	// cache.memory.maxbytes
+	EvictedCapacity
+	// EvictedDeleted entries were deleted, by key or by tag
+	EvictedDeleted
+)
+
+var (
+	ErrUnknownEviction  = errors.New("unknown eviction policy")
+	ErrStatsUnsupported = errors.New("stats not supported")
+
+	evictedMu sync.RWMutex
+	evicted   []func(key string, value interface{}, reason EvictionReason)
+)
+
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedExpired:
+		return "expired"
+	case EvictedCapacity:
+		return "capacity"
+	case EvictedDeleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// Stats of a bounded cache
+type Stats struct {
+	Entries   int
+	Bytes     int64
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// HitRatio of the reads, 0 before the first one
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// statser is implemented by the backends keeping Stats
+type statser interface {
+	Stats() Stats
+}
+
+// GetStats of the local or remote cache
+func GetStats(remote bool) (Stats, error) {
+	c := getCache(remote)
+	s, ok := c.(statser)
+	if !ok {
+		return Stats{}, fmt.Errorf("%w: %s", ErrStatsUnsupported, backendName(c))
+	}
+	return s.Stats(), nil
+}
+
+// OnEvicted calls fn with the entries leaving the in-memory caches, after the
+// cache is unlocked so that fn may use it.
+func OnEvicted(fn func(key string, value interface{}, reason EvictionReason)) {
+	evictedMu.Lock()
+	defer evictedMu.Unlock()
+	evicted = append(evicted, fn)
+}
+
+func notifyEvicted(e *entry, reason EvictionReason) {
+	metrics.CacheEvictions.WithLabelValues(reason.String()).Inc()
+	evictedMu.RLock()
+	defer evictedMu.RUnlock()
+	for _, fn := range evicted {
+		fn(e.key, e.value, reason)
+	}
+}
+
+// entry of the in-memory cache
+type entry struct {
+	key     string
+	value   interface{}
+	expires int64
+	size    int64
+
+	// element in the lru list
+	element *list.Element
+	// frequency, last access and index in the lfu heap
+	frequency uint64
+	accessed  uint64
+	index     int
+}
+
+// evictionPolicy orders the entries to evict when the cache is full
+type evictionPolicy interface {
+	add(e *entry)
+	access(e *entry)
+	remove(e *entry)
+	// victim is the next entry to evict, nil when empty
+	victim() *entry
+}
+
+func newEvictionPolicy(name string) (evictionPolicy, error) {
+	switch name {
+	case "lru":
+		return &lruPolicy{entries: list.New()}, nil
+	case "lfu":
+		return &lfuPolicy{}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownEviction, name)
+}
+
+// lruPolicy evicts the least recently used entry
+type lruPolicy struct {
+	entries *list.List
+}
+
+func (p *lruPolicy) add(e *entry) {
+	e.element = p.entries.PushFront(e)
+}
+
+func (p *lruPolicy) access(e *entry) {
+	p.entries.MoveToFront(e.element)
+}
+
+func (p *lruPolicy) remove(e *entry) {
+	p.entries.Remove(e.element)
+	e.element = nil
+}
+
+func (p *lruPolicy) victim() *entry {
+	back := p.entries.Back()
+	if back == nil {
+		return nil
+	}
+	return back.Value.(*entry)
+}
+
+// lfuPolicy evicts the least frequently used entry, the least recently used
+// of them on a tie so that new entries are not evicted before the old ones.
+type lfuPolicy struct {
+	entries []*entry
+	clock   uint64
+}
+
+func (p *lfuPolicy) add(e *entry) {
+	p.clock++
+	e.frequency = 1
+	e.accessed = p.clock
+	heap.Push(p, e)
+}
+
+func (p *lfuPolicy) access(e *entry) {
+	p.clock++
+	e.frequency++
+	e.accessed = p.clock
+	heap.Fix(p, e.index)
+}
+
+func (p *lfuPolicy) remove(e *entry) {
+	heap.Remove(p, e.index)
+}
+
+func (p *lfuPolicy) victim() *entry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	return p.entries[0]
+}
+
+func (p *lfuPolicy) Len() int {
+	return len(p.entries)
+}
+
+func (p *lfuPolicy) Less(i, j int) bool {
+	if p.entries[i].frequency != p.entries[j].frequency {
+		return p.entries[i].frequency < p.entries[j].frequency
+	}
+	return p.entries[i].accessed < p.entries[j].accessed
+}
+
+func (p *lfuPolicy) Swap(i, j int) {
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+	p.entries[i].index = i
+	p.entries[j].index = j
+}
+
+func (p *lfuPolicy) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *lfuPolicy) Pop() interface{} {
+	last := len(p.entries) - 1
+	e := p.entries[last]
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+	e.index = -1
+	return e
+}
+
+// sizeOf estimates the bytes held by an entry of key and value
+func sizeOf(key string, value interface{}) int64 {
+	const overhead = 64
+	return overhead + int64(len(key)) + valueSize(reflect.ValueOf(value), 0)
+}
+
+// valueSize estimates the bytes held by v, the pointers are followed a few
+// levels deep only, shared or cyclic data is counted each time it is reached.
+func valueSize(v reflect.Value, depth int) int64 {
+	if !v.IsValid() || depth > 8 {
+		return 0
+	}
+	size := int64(v.Type().Size())
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			size += valueSize(v.Elem(), depth+1)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return size + int64(v.Cap())
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			size += valueSize(v.Index(i), depth+1)
+		}
+		if v.Kind() == reflect.Array {
+			// the elements are inline, counted by Size already
+			size -= int64(v.Type().Size())
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += valueSize(iter.Key(), depth+1) + valueSize(iter.Value(), depth+1)
+		}
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += valueSize(v.Field(i), depth+1)
+		}
+	}
+	return size
+}
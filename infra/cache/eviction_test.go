@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// boundedCache configured with the eviction policy and limits
+func boundedCache(t *testing.T, eviction string, maxEntries int, maxBytes int64) *inMemoryCache {
+	viper.Set("test.memory.eviction", eviction)
+	viper.Set("test.memory.maxentries", maxEntries)
+	viper.Set("test.memory.maxbytes", maxBytes)
+	t.Cleanup(func() { viper.Set("test.memory", nil) })
+	c := newInMemoryCache(time.Hour)
+	if err := c.configure("test.memory"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// cached keys of c among keys, without accessing them
+func cached(c *inMemoryCache, keys ...string) []string {
+	c.mu.Lock()
+	defer c.unlock()
+	found := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := c.entries[key]; ok {
+			found = append(found, key)
+		}
+	}
+	return found
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	c := boundedCache(t, "lru", 3, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		_ = c.Set(key, key, ForEverNeverExpiry)
+	}
+	var value string
+	_ = c.Get("a", &value)
+	_ = c.Set("d", "d", ForEverNeverExpiry)
+
+	if got := strings.Join(cached(c, "a", "b", "c", "d"), ","); got != "a,c,d" {
+		t.Fatalf("expected b evicted, cached %s", got)
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestEvictLeastFrequentlyUsed(t *testing.T) {
+	c := boundedCache(t, "lfu", 3, 0)
+	for _, key := range []string{"a", "b", "c"} {
+		_ = c.Set(key, key, ForEverNeverExpiry)
+	}
+	var value string
+	_ = c.Get("a", &value)
+	_ = c.Get("a", &value)
+	_ = c.Get("c", &value)
+
+	// b is the least used, then d, the least recently used of those used once
+	_ = c.Set("d", "d", ForEverNeverExpiry)
+	if got := strings.Join(cached(c, "a", "b", "c", "d"), ","); got != "a,c,d" {
+		t.Fatalf("expected b evicted, cached %s", got)
+	}
+	_ = c.Set("e", "e", ForEverNeverExpiry)
+	_ = c.Get("e", &value)
+	_ = c.Set("f", "f", ForEverNeverExpiry)
+	if got := strings.Join(cached(c, "a", "c", "d", "e"), ","); got != "a,c,e" {
+		t.Fatalf("expected d evicted, cached %s", got)
+	}
+}
+
+func TestEvictBeyondMaxBytes(t *testing.T) {
+	value := strings.Repeat("x", 1000)
+	size := sizeOf("key0", value)
+	c := boundedCache(t, "lru", 0, 3*size)
+
+	var victims []string
+	evictedMu.Lock()
+	previous := evicted
+	evictedMu.Unlock()
+	OnEvicted(func(key string, value interface{}, reason EvictionReason) {
+		if reason == EvictedCapacity {
+			victims = append(victims, key)
+		}
+	})
+	t.Cleanup(func() {
+		evictedMu.Lock()
+		defer evictedMu.Unlock()
+		evicted = previous
+	})
+
+	for _, key := range []string{"key0", "key1", "key2", "key3"} {
+		_ = c.Set(key, value, ForEverNeverExpiry)
+	}
+	if stats := c.Stats(); stats.Bytes > 3*size || stats.Entries != 3 {
+		t.Fatalf("limit of %d bytes exceeded: %+v", 3*size, stats)
+	}
+	if len(victims) != 1 || victims[0] != "key0" {
+		t.Fatalf("expected key0 evicted, got %v", victims)
+	}
+
+	// a value larger than the limit is not stored
+	_ = c.Set("large", strings.Repeat("x", int(4*size)), ForEverNeverExpiry)
+	if got := cached(c, "large"); len(got) != 0 {
+		t.Fatal("value beyond maxbytes stored")
+	}
+}
+
+func TestReconfigureEviction(t *testing.T) {
+	c := boundedCache(t, "lru", 0, 0)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		_ = c.Set(key, key, ForEverNeverExpiry)
+	}
+	viper.Set("test.memory.eviction", "lfu")
+	viper.Set("test.memory.maxentries", 2)
+	if err := c.configure("test.memory"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats.Entries != 2 {
+		t.Fatalf("expected the entries beyond the new limit evicted, got %+v", stats)
+	}
+
+	viper.Set("test.memory.eviction", "random")
+	if err := c.configure("test.memory"); err == nil {
+		t.Fatal("expected an unknown eviction policy to fail")
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var (
+	ErrExtendedUnsupported = errors.New("redis data structures not supported")
+	ErrWrongType           = errors.New("wrong type of value")
+	ErrCommandUnsupported  = errors.New("command not emulated")
+	ErrScriptUnsupported   = errors.New("script not emulated")
+	ErrSyntax              = errors.New("syntax error")
+)
+
+// RedisExtended operates on the hashes, sorted sets, lists and sets of redis.
+// The in-memory cache emulates it for the unit tests, its data structures are
+// evicted and expire like its other entries. A missing key is an empty
+// structure, ErrWrongType is returned for a key holding another type.
+type RedisExtended interface {
+	// HSet the fields of the hash, returns the number of fields added
+	HSet(ctx context.Context, key string, fields map[string]string) (int, error)
+	// HGet a field of the hash, ErrCacheMiss when missing
+	HGet(ctx context.Context, key string, field string) (string, error)
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// HDel the fields of the hash, returns the number of fields removed
+	HDel(ctx context.Context, key string, fields ...string) (int, error)
+	HIncrBy(ctx context.Context, key string, field string, n int64) (int64, error)
+
+	// ZAdd the members to the sorted set or updates their score, returns the
+	// number of members added
+	ZAdd(ctx context.Context, key string, members ...ZMember) (int, error)
+	ZIncrBy(ctx context.Context, key string, member string, n float64) (float64, error)
+	// ZScore of the member, ErrCacheMiss when missing
+	ZScore(ctx context.Context, key string, member string) (float64, error)
+	// ZRevRank of the member from the highest score, ErrCacheMiss when missing
+	ZRevRank(ctx context.Context, key string, member string) (int, error)
+	// ZRange of the members from start to stop included by ascending score,
+	// negative indexes count from the end.
+	ZRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error)
+	// ZRevRange is ZRange by descending score, the top 10 are ZRevRange(0, 9)
+	ZRevRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error)
+	ZRem(ctx context.Context, key string, members ...string) (int, error)
+	ZCard(ctx context.Context, key string) (int, error)
+
+	// LPush the values at the head of the list, returns its length
+	LPush(ctx context.Context, key string, values ...string) (int, error)
+	// RPush the values at the tail of the list, returns its length
+	RPush(ctx context.Context, key string, values ...string) (int, error)
+	// LPop the head of the list, ErrCacheMiss when empty
+	LPop(ctx context.Context, key string) (string, error)
+	// RPop the tail of the list, ErrCacheMiss when empty
+	RPop(ctx context.Context, key string) (string, error)
+	// LRange of the values from start to stop included, negative indexes
+	// count from the end.
+	LRange(ctx context.Context, key string, start int, stop int) ([]string, error)
+	LLen(ctx context.Context, key string) (int, error)
+
+	// SAdd the members to the set, returns the number of members added
+	SAdd(ctx context.Context, key string, members ...string) (int, error)
+	// SRem the members from the set, returns the number of members removed
+	SRem(ctx context.Context, key string, members ...string) (int, error)
+	SIsMember(ctx context.Context, key string, member string) (bool, error)
+	SMembers(ctx context.Context, key string) ([]string, error)
+	SCard(ctx context.Context, key string) (int, error)
+
+	// Expire key after ttl, false when it does not exist
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+
+	// Pipeline sends the commands to the node of key in one round trip, their
+	// keys must be on the same node, share a {tag} with key in cluster mode.
+	// The replies are in the order of the commands, a command failing has its
+	// error as reply, the first error is returned too. The replies are those
+	// of redigo, decoded with redis.Int, redis.String...
+	Pipeline(ctx context.Context, key string, commands ...Command) ([]interface{}, error)
+	// Eval the script on the node of its keys, which must be on one node
+	Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// ZMember of a sorted set
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// Command of a Pipeline, like Cmd("HINCRBY", key, "visits", 1)
+type Command struct {
+	Name string
+	Args []interface{}
+}
+
+// Cmd is the Command name with args
+func Cmd(name string, args ...interface{}) Command {
+	return Command{Name: name, Args: args}
+}
+
+// Script is a Lua script run by Eval. The in-memory cache cannot run Lua, it
+// runs the Go function given to Emulate instead, one script at a time: the
+// emulation is atomic for the keys changed by scripts only.
+type Script struct {
+	keyCount int
+	script   *redis.Script
+	emulate  func(ctx context.Context, r RedisExtended, keys []string, args []interface{}) (interface{}, error)
+}
+
+// NewScript of src taking keyCount keys, loaded on redis the first time it
+// is run.
+func NewScript(keyCount int, src string) *Script {
+	return &Script{
+		keyCount: keyCount,
+		script:   redis.NewScript(keyCount, src),
+	}
+}
+
+// Emulate the script with fn on the in-memory cache, its reply is that the
+// script would give.
+func (s *Script) Emulate(fn func(ctx context.Context, r RedisExtended, keys []string, args []interface{}) (interface{}, error)) *Script {
+	s.emulate = fn
+	return s
+}
+
+// Extended data structures of the local or remote cache, the remote one is
+// swapped when its configuration changes so get it per use.
+func Extended(remote bool) (RedisExtended, error) {
+	c := getCache(remote)
+	extended, ok := c.(RedisExtended)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExtendedUnsupported, backendName(c))
+	}
+	return extended, nil
+}
@@ -1,56 +1,151 @@
 package cache
 
 import (
+	"container/list"
+	"context"
 	"fmt"
-	"go-microservice/infra/server"
 	"reflect"
 	"sync"
 	"time"
 
-	"github.com/patrickmn/go-cache"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
+// inMemoryCache keeps at most cache.memory.maxentries entries holding about
+// cache.memory.maxbytes, evicting the entries chosen by cache.memory.eviction
+// to make room.
 type inMemoryCache struct {
-	cache cache.Cache
-	mu    sync.RWMutex
+	mu                sync.Mutex
+	entries           map[string]*entry
+	policy            evictionPolicy
+	policyName        string
+	defaultExpiration time.Duration
+	maxEntries        int
+	maxBytes          int64
+	bytes             int64
+	hits              uint64
+	misses            uint64
+	evictions         uint64
+	evicted           []evictedEntry
+	tags              map[string]map[string]bool
+	keyTags           map[string][]string
+	stop              chan struct{}
+	once              sync.Once
+	// scripts are emulated one at a time
+	scripts sync.Mutex
+}
+
+// evictedEntry waits for the cache to be unlocked to be notified
+type evictedEntry struct {
+	entry  *entry
+	reason EvictionReason
 }
 
 func init() {
-	defaultExpiration := time.Hour
-	internalCache = &inMemoryCache{
-		cache: *cache.New(defaultExpiration, time.Minute),
-		mu:    sync.RWMutex{},
+	viper.SetDefault("cache.memory.maxentries", 100000)
+	viper.SetDefault("cache.memory.maxbytes", 128<<20)
+	viper.SetDefault("cache.memory.eviction", "lru")
+	RegisterBackend("memory", func() (Cache, error) {
+		c := newInMemoryCache(defaultTTL())
+		if err := c.Init(); err != nil {
+			return nil, err
+		}
+		go c.janitor(time.Minute)
+		return c, nil
+	}, "cache.memory.maxentries", "cache.memory.maxbytes", "cache.memory.eviction")
+}
+
+// newInMemoryCache without limits, evicting the least recently used entries
+// once limited.
+func newInMemoryCache(defaultExpiration time.Duration) *inMemoryCache {
+	return &inMemoryCache{
+		entries:           make(map[string]*entry),
+		policy:            &lruPolicy{entries: list.New()},
+		policyName:        "lru",
+		defaultExpiration: defaultExpiration,
+		tags:              make(map[string]map[string]bool),
+		keyTags:           make(map[string][]string),
+		stop:              make(chan struct{}),
 	}
-	server.RegisterService(internalCache.(*inMemoryCache), server.Low)
 }
 
-func (c *inMemoryCache) Init() (err error) {
+// Init the limits and the eviction policy from the configuration
+func (c *inMemoryCache) Init() error {
+	return c.configure("cache.memory")
+}
+
+// configure the limits and the eviction policy from the maxentries, maxbytes
+// and eviction keys under prefix
+func (c *inMemoryCache) configure(prefix string) error {
+	policyName := viper.GetString(prefix + ".eviction")
+	policy, err := newEvictionPolicy(policyName)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	c.defaultExpiration = defaultTTL()
+	c.maxEntries = viper.GetInt(prefix + ".maxentries")
+	c.maxBytes = viper.GetInt64(prefix + ".maxbytes")
+	if policyName != c.policyName {
+		// the entries are ordered by the new policy as if just added
+		for _, e := range c.entries {
+			c.policy.remove(e)
+			policy.add(e)
+		}
+		c.policy = policy
+		c.policyName = policyName
+	}
+	c.evict()
 	return nil
 }
 
+// OnConfig applies the new limits, evicting the entries beyond them
 func (c *inMemoryCache) OnConfig() {
+	if err := c.Init(); err != nil {
+		log.WithField("Error", err).Error("Reconfiguring in-memory cache failed, keeping the current eviction policy")
+	}
 }
 
-func newInMemoryCache(defaultExpiration time.Duration) *inMemoryCache {
-	return &inMemoryCache{
-		cache: *cache.New(defaultExpiration, time.Minute),
-		mu:    sync.RWMutex{},
+func (c *inMemoryCache) Stop(ctx context.Context) error {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+func (c *inMemoryCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.unlock()
+	return Stats{
+		Entries:   len(c.entries),
+		Bytes:     c.bytes,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
 	}
 }
 
 func (c *inMemoryCache) Get(key string, ptrValue interface{}) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	c.mu.Lock()
+	defer c.unlock()
 
-	value, found := c.cache.Get(key)
+	e, found := c.get(key)
 	if !found {
+		c.misses++
 		return ErrCacheMiss
 	}
+	c.hits++
+	c.policy.access(e)
 
 	v := reflect.ValueOf(ptrValue)
 	if v.Type().Kind() == reflect.Ptr && v.Elem().CanSet() {
-		v.Elem().Set(reflect.ValueOf(value))
+		if !reflect.TypeOf(e.value).AssignableTo(v.Elem().Type()) {
+			return ErrInvalidValue
+		}
+		v.Elem().Set(reflect.ValueOf(e.value))
 		return nil
 	}
 
@@ -65,84 +160,273 @@ func (c *inMemoryCache) GetMulti(keys ...string) (Getter, error) {
 
 func (c *inMemoryCache) Set(key string, value interface{}, expires time.Duration) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.cache.Set(key, value, expires)
+	defer c.unlock()
+	c.set(key, plainValue(value), expires)
+	return nil
+}
+
+func (c *inMemoryCache) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.set(key, plainValue(value), expires) {
+		return nil
+	}
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]bool)
+		}
+		c.tags[tag][key] = true
+	}
+	c.keyTags[key] = tags
+	return nil
+}
+
+func (c *inMemoryCache) InvalidateTag(tags ...string) error {
+	c.mu.Lock()
+	defer c.unlock()
+	keys := make([]string, 0)
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		if e, found := c.entries[key]; found {
+			c.remove(e, EvictedDeleted)
+		}
+	}
 	return nil
 }
 
 func (c *inMemoryCache) Add(key string, value interface{}, expires time.Duration) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	err := c.cache.Add(key, value, expires)
-	if err != nil {
+	defer c.unlock()
+	if _, found := c.get(key); found {
 		return ErrNotStored
 	}
-	return err
+	c.set(key, plainValue(value), expires)
+	return nil
 }
 
 func (c *inMemoryCache) Replace(key string, value interface{}, expires time.Duration) error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if err := c.cache.Replace(key, value, expires); err != nil {
+	defer c.unlock()
+	if _, found := c.get(key); !found {
 		return ErrNotStored
 	}
+	c.set(key, plainValue(value), expires)
 	return nil
 }
 
 func (c *inMemoryCache) Delete(key string) error {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	if _, found := c.cache.Get(key); !found {
+	c.mu.Lock()
+	defer c.unlock()
+	e, found := c.get(key)
+	if !found {
 		return ErrCacheMiss
 	}
-	c.cache.Delete(key)
+	c.remove(e, EvictedDeleted)
 	return nil
 }
 
 func (c *inMemoryCache) Increment(key string, n uint64) (newValue uint64, err error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if _, found := c.cache.Get(key); !found {
+	defer c.unlock()
+	e, found := c.get(key)
+	if !found {
 		return 0, ErrCacheMiss
 	}
-	if err = c.cache.Increment(key, int64(n)); err != nil {
+	value, err := addInteger(e.value, int64(n))
+	if err != nil {
 		return
 	}
+	e.value = value
 
-	return c.convertTypeToUint64(key)
+	return convertTypeToUint64(e.value)
 }
 
 func (c *inMemoryCache) Decrement(key string, n uint64) (newValue uint64, err error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if nv, err := c.convertTypeToUint64(key); err != nil {
+	defer c.unlock()
+	e, found := c.get(key)
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	if nv, err := convertTypeToUint64(e.value); err != nil {
 		return 0, err
 	} else {
 		if n > nv {
 			n = nv
 		}
 	}
-	if err = c.cache.Decrement(key, int64(n)); err != nil {
+	value, err := addInteger(e.value, -int64(n))
+	if err != nil {
 		return
 	}
+	e.value = value
 
-	return c.convertTypeToUint64(key)
+	return convertTypeToUint64(e.value)
 }
 
 func (c *inMemoryCache) Flush() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	c.cache.Flush()
+	for _, e := range c.entries {
+		c.policy.remove(e)
+	}
+	c.entries = make(map[string]*entry)
+	c.bytes = 0
+	c.tags = make(map[string]map[string]bool)
+	c.keyTags = make(map[string][]string)
 	return nil
 }
 
-func (c *inMemoryCache) convertTypeToUint64(key string) (newValue uint64, err error) {
-	v, found := c.cache.Get(key)
+// unlock mu and notify the entries evicted meanwhile
+func (c *inMemoryCache) unlock() {
+	evicted := c.evicted
+	c.evicted = nil
+	c.mu.Unlock()
+	for _, e := range evicted {
+		notifyEvicted(e.entry, e.reason)
+	}
+}
+
+// get the entry of key unless expired, called with mu held
+func (c *inMemoryCache) get(key string) (*entry, bool) {
+	e, found := c.entries[key]
 	if !found {
-		return newValue, ErrCacheMiss
+		return nil, false
+	}
+	if e.expires > 0 && e.expires <= time.Now().UnixNano() {
+		c.remove(e, EvictedExpired)
+		return nil, false
+	}
+	return e, true
+}
+
+// set key to value, the entries beyond the limits are evicted. A value larger
+// than cache.memory.maxbytes is not stored, false is returned. Called with mu
+// held.
+func (c *inMemoryCache) set(key string, value interface{}, expires time.Duration) bool {
+	if previous, found := c.entries[key]; found {
+		c.policy.remove(previous)
+		c.bytes -= previous.size
+		delete(c.entries, key)
+		c.untag(key)
+	}
+	e := &entry{
+		key:   key,
+		value: value,
+		size:  sizeOf(key, value),
 	}
+	if c.maxBytes > 0 && e.size > c.maxBytes {
+		return false
+	}
+	switch expires {
+	case DefaultExpiryTime:
+		expires = c.defaultExpiration
+	case ForEverNeverExpiry:
+		expires = 0
+	}
+	if expires > 0 {
+		e.expires = time.Now().Add(expires).UnixNano()
+	}
+
+	c.entries[key] = e
+	c.bytes += e.size
+	c.policy.add(e)
+	c.evict()
+	return true
+}
+
+// evict the entries beyond the limits, called with mu held
+func (c *inMemoryCache) evict() {
+	for (c.maxEntries > 0 && len(c.entries) > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		victim := c.policy.victim()
+		if victim == nil {
+			return
+		}
+		c.evictions++
+		c.remove(victim, EvictedCapacity)
+	}
+}
+
+// remove the entry, notified once mu is unlocked. Called with mu held.
+func (c *inMemoryCache) remove(e *entry, reason EvictionReason) {
+	c.policy.remove(e)
+	c.bytes -= e.size
+	delete(c.entries, e.key)
+	c.untag(e.key)
+	c.evicted = append(c.evicted, evictedEntry{entry: e, reason: reason})
+}
+
+// janitor removes the expired entries every interval until stopped
+func (c *inMemoryCache) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			now := time.Now().UnixNano()
+			for _, e := range c.entries {
+				if e.expires > 0 && e.expires <= now {
+					c.remove(e, EvictedExpired)
+				}
+			}
+			c.unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// untag key from the tags it was set with, called with mu held
+func (c *inMemoryCache) untag(key string) {
+	for _, tag := range c.keyTags[key] {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+// addInteger n to the integer or float value
+func addInteger(value interface{}, n int64) (interface{}, error) {
+	switch v := value.(type) {
+	case int:
+		return v + int(n), nil
+	case int8:
+		return v + int8(n), nil
+	case int16:
+		return v + int16(n), nil
+	case int32:
+		return v + int32(n), nil
+	case int64:
+		return v + n, nil
+	case uint:
+		return v + uint(n), nil
+	case uintptr:
+		return v + uintptr(n), nil
+	case uint8:
+		return v + uint8(n), nil
+	case uint16:
+		return v + uint16(n), nil
+	case uint32:
+		return v + uint32(n), nil
+	case uint64:
+		return v + uint64(n), nil
+	case float32:
+		return v + float32(n), nil
+	case float64:
+		return v + float64(n), nil
+	}
+	return nil, ErrInvalidValue
+}
 
+func convertTypeToUint64(v interface{}) (newValue uint64, err error) {
 	switch v.(type) {
 	case int:
 		newValue = uint64(v.(int))
@@ -0,0 +1,866 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The data structures emulated by the in-memory cache
+type memoryHash map[string]string
+
+type memorySortedSet map[string]float64
+
+type memorySet map[string]bool
+
+type memoryList struct {
+	values []string
+}
+
+func newMemoryHash() memoryHash {
+	return make(memoryHash)
+}
+
+func newMemorySortedSet() memorySortedSet {
+	return make(memorySortedSet)
+}
+
+func newMemorySet() memorySet {
+	return make(memorySet)
+}
+
+func newMemoryList() *memoryList {
+	return &memoryList{}
+}
+
+func (c *inMemoryCache) HSet(ctx context.Context, key string, fields map[string]string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	hash, err := structure(c, key, newMemoryHash)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for field, value := range fields {
+		if _, ok := hash[field]; !ok {
+			added++
+		}
+		hash[field] = value
+	}
+	c.resized(key, len(hash) == 0)
+	return added, nil
+}
+
+func (c *inMemoryCache) HGet(ctx context.Context, key string, field string) (string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	hash, err := structure[memoryHash](c, key, nil)
+	if err != nil {
+		return "", err
+	}
+	value, ok := hash[field]
+	if !ok {
+		return "", ErrCacheMiss
+	}
+	return value, nil
+}
+
+func (c *inMemoryCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	hash, err := structure[memoryHash](c, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(hash))
+	for field, value := range hash {
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+func (c *inMemoryCache) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	hash, err := structure[memoryHash](c, key, nil)
+	if err != nil || hash == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if _, ok := hash[field]; ok {
+			delete(hash, field)
+			removed++
+		}
+	}
+	c.resized(key, len(hash) == 0)
+	return removed, nil
+}
+
+func (c *inMemoryCache) HIncrBy(ctx context.Context, key string, field string, n int64) (int64, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	hash, err := structure(c, key, newMemoryHash)
+	if err != nil {
+		return 0, err
+	}
+	var value int64
+	if current, ok := hash[field]; ok {
+		if value, err = strconv.ParseInt(current, 10, 64); err != nil {
+			return 0, ErrInvalidValue
+		}
+	}
+	value += n
+	hash[field] = strconv.FormatInt(value, 10)
+	c.resized(key, false)
+	return value, nil
+}
+
+func (c *inMemoryCache) ZAdd(ctx context.Context, key string, members ...ZMember) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure(c, key, newMemorySortedSet)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		if _, ok := set[member.Member]; !ok {
+			added++
+		}
+		set[member.Member] = member.Score
+	}
+	c.resized(key, len(set) == 0)
+	return added, nil
+}
+
+func (c *inMemoryCache) ZIncrBy(ctx context.Context, key string, member string, n float64) (float64, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure(c, key, newMemorySortedSet)
+	if err != nil {
+		return 0, err
+	}
+	set[member] += n
+	c.resized(key, false)
+	return set[member], nil
+}
+
+func (c *inMemoryCache) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	score, ok := set[member]
+	if !ok {
+		return 0, ErrCacheMiss
+	}
+	return score, nil
+}
+
+func (c *inMemoryCache) ZRevRank(ctx context.Context, key string, member string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := set[member]; !ok {
+		return 0, ErrCacheMiss
+	}
+	members := set.sorted()
+	for i := range members {
+		if members[len(members)-1-i].Member == member {
+			return i, nil
+		}
+	}
+	return 0, ErrCacheMiss
+}
+
+func (c *inMemoryCache) ZRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	members := set.sorted()
+	from, to := span(start, stop, len(members))
+	return members[from:to], nil
+}
+
+func (c *inMemoryCache) ZRevRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	members := set.sorted()
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+	from, to := span(start, stop, len(members))
+	return members[from:to], nil
+}
+
+func (c *inMemoryCache) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			removed++
+		}
+	}
+	c.resized(key, len(set) == 0)
+	return removed, nil
+}
+
+func (c *inMemoryCache) ZCard(ctx context.Context, key string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySortedSet](c, key, nil)
+	return len(set), err
+}
+
+func (c *inMemoryCache) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure(c, key, newMemoryList)
+	if err != nil {
+		return 0, err
+	}
+	// each value is pushed at the head in turn, the last one ends first
+	pushed := make([]string, 0, len(values)+len(list.values))
+	for i := len(values) - 1; i >= 0; i-- {
+		pushed = append(pushed, values[i])
+	}
+	list.values = append(pushed, list.values...)
+	c.resized(key, len(list.values) == 0)
+	return len(list.values), nil
+}
+
+func (c *inMemoryCache) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure(c, key, newMemoryList)
+	if err != nil {
+		return 0, err
+	}
+	list.values = append(list.values, values...)
+	c.resized(key, len(list.values) == 0)
+	return len(list.values), nil
+}
+
+func (c *inMemoryCache) LPop(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure[*memoryList](c, key, nil)
+	if err != nil {
+		return "", err
+	} else if list == nil {
+		return "", ErrCacheMiss
+	}
+	value := list.values[0]
+	list.values = list.values[1:]
+	c.resized(key, len(list.values) == 0)
+	return value, nil
+}
+
+func (c *inMemoryCache) RPop(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure[*memoryList](c, key, nil)
+	if err != nil {
+		return "", err
+	} else if list == nil {
+		return "", ErrCacheMiss
+	}
+	last := len(list.values) - 1
+	value := list.values[last]
+	list.values = list.values[:last]
+	c.resized(key, len(list.values) == 0)
+	return value, nil
+}
+
+func (c *inMemoryCache) LRange(ctx context.Context, key string, start int, stop int) ([]string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure[*memoryList](c, key, nil)
+	if err != nil {
+		return nil, err
+	} else if list == nil {
+		return []string{}, nil
+	}
+	from, to := span(start, stop, len(list.values))
+	values := make([]string, to-from)
+	copy(values, list.values[from:to])
+	return values, nil
+}
+
+func (c *inMemoryCache) LLen(ctx context.Context, key string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	list, err := structure[*memoryList](c, key, nil)
+	if err != nil || list == nil {
+		return 0, err
+	}
+	return len(list.values), nil
+}
+
+func (c *inMemoryCache) SAdd(ctx context.Context, key string, members ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure(c, key, newMemorySet)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		if !set[member] {
+			set[member] = true
+			added++
+		}
+	}
+	c.resized(key, len(set) == 0)
+	return added, nil
+}
+
+func (c *inMemoryCache) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySet](c, key, nil)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if set[member] {
+			delete(set, member)
+			removed++
+		}
+	}
+	c.resized(key, len(set) == 0)
+	return removed, nil
+}
+
+func (c *inMemoryCache) SIsMember(ctx context.Context, key string, member string) (bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySet](c, key, nil)
+	return set[member], err
+}
+
+// SMembers of the set, sorted unlike redis
+func (c *inMemoryCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySet](c, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (c *inMemoryCache) SCard(ctx context.Context, key string) (int, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	set, err := structure[memorySet](c, key, nil)
+	return len(set), err
+}
+
+func (c *inMemoryCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	e, found := c.get(key)
+	if !found {
+		return false, nil
+	}
+	if ttl <= 0 {
+		c.remove(e, EvictedExpired)
+		return true, nil
+	}
+	e.expires = time.Now().Add(ttl).UnixNano()
+	return true, nil
+}
+
+// Pipeline runs the commands one after the other, the commands of the
+// RedisExtended methods, DEL, GET, SET and INCR are emulated.
+func (c *inMemoryCache) Pipeline(ctx context.Context, key string, commands ...Command) ([]interface{}, error) {
+	replies := make([]interface{}, len(commands))
+	var firstErr error
+	for i, command := range commands {
+		reply, err := c.command(ctx, command)
+		if err != nil {
+			reply = err
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+func (c *inMemoryCache) Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	if len(keys) != script.keyCount {
+		return nil, fmt.Errorf("%w: the script takes %d keys, got %d", ErrSyntax, script.keyCount, len(keys))
+	}
+	if script.emulate == nil {
+		return nil, ErrScriptUnsupported
+	}
+	c.scripts.Lock()
+	defer c.scripts.Unlock()
+	return script.emulate(ctx, c, keys, args)
+}
+
+// command emulated, its reply is that of redigo
+func (c *inMemoryCache) command(ctx context.Context, command Command) (interface{}, error) {
+	name := strings.ToUpper(command.Name)
+	if len(command.Args) == 0 {
+		return nil, fmt.Errorf("%w: %s takes a key", ErrSyntax, name)
+	}
+	args := make([]string, len(command.Args))
+	for i, arg := range command.Args {
+		args[i] = argString(arg)
+	}
+	key, args := args[0], args[1:]
+
+	switch name {
+	case "DEL":
+		return c.del(append([]string{key}, args...))
+	case "GET":
+		if len(args) != 0 {
+			return nil, arity(name)
+		}
+		return bulkReply(c.getString(key))
+	case "SET":
+		return c.setString(key, args)
+	case "INCR":
+		if len(args) != 0 {
+			return nil, arity(name)
+		}
+		return c.incr(key)
+	case "HSET":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, arity(name)
+		}
+		fields := make(map[string]string, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			fields[args[i]] = args[i+1]
+		}
+		return intReply(c.HSet(ctx, key, fields))
+	case "HGET":
+		if len(args) != 1 {
+			return nil, arity(name)
+		}
+		return bulkReply(c.HGet(ctx, key, args[0]))
+	case "HGETALL":
+		fields, err := c.HGetAll(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		reply := make([]interface{}, 0, 2*len(fields))
+		for field, value := range fields {
+			reply = append(reply, []byte(field), []byte(value))
+		}
+		return reply, nil
+	case "HDEL":
+		return intReply(c.HDel(ctx, key, args...))
+	case "HINCRBY":
+		if len(args) != 2 {
+			return nil, arity(name)
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not an integer", ErrSyntax, args[1])
+		}
+		return c.HIncrBy(ctx, key, args[0], n)
+	case "ZADD":
+		if len(args) == 0 || len(args)%2 != 0 {
+			return nil, arity(name)
+		}
+		members := make([]ZMember, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s is not a float", ErrSyntax, args[i])
+			}
+			members = append(members, ZMember{Member: args[i+1], Score: score})
+		}
+		return intReply(c.ZAdd(ctx, key, members...))
+	case "ZINCRBY":
+		if len(args) != 2 {
+			return nil, arity(name)
+		}
+		n, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not a float", ErrSyntax, args[0])
+		}
+		return floatReply(c.ZIncrBy(ctx, key, args[1], n))
+	case "ZSCORE":
+		if len(args) != 1 {
+			return nil, arity(name)
+		}
+		return floatReply(c.ZScore(ctx, key, args[0]))
+	case "ZREVRANK":
+		if len(args) != 1 {
+			return nil, arity(name)
+		}
+		rank, err := c.ZRevRank(ctx, key, args[0])
+		if err == ErrCacheMiss {
+			return nil, nil
+		}
+		return intReply(rank, err)
+	case "ZRANGE", "ZREVRANGE":
+		if len(args) != 2 && !(len(args) == 3 && strings.EqualFold(args[2], "WITHSCORES")) {
+			return nil, arity(name)
+		}
+		start, stop, err := indexes(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		var members []ZMember
+		if name == "ZRANGE" {
+			members, err = c.ZRange(ctx, key, start, stop)
+		} else {
+			members, err = c.ZRevRange(ctx, key, start, stop)
+		}
+		if err != nil {
+			return nil, err
+		}
+		reply := make([]interface{}, 0, 2*len(members))
+		for _, member := range members {
+			reply = append(reply, []byte(member.Member))
+			if len(args) == 3 {
+				reply = append(reply, []byte(strconv.FormatFloat(member.Score, 'g', -1, 64)))
+			}
+		}
+		return reply, nil
+	case "ZREM":
+		return intReply(c.ZRem(ctx, key, args...))
+	case "ZCARD":
+		return intReply(c.ZCard(ctx, key))
+	case "LPUSH":
+		return intReply(c.LPush(ctx, key, args...))
+	case "RPUSH":
+		return intReply(c.RPush(ctx, key, args...))
+	case "LPOP":
+		return bulkReply(c.LPop(ctx, key))
+	case "RPOP":
+		return bulkReply(c.RPop(ctx, key))
+	case "LRANGE":
+		if len(args) != 2 {
+			return nil, arity(name)
+		}
+		start, stop, err := indexes(args[0], args[1])
+		if err != nil {
+			return nil, err
+		}
+		return bulksReply(c.LRange(ctx, key, start, stop))
+	case "LLEN":
+		return intReply(c.LLen(ctx, key))
+	case "SADD":
+		return intReply(c.SAdd(ctx, key, args...))
+	case "SREM":
+		return intReply(c.SRem(ctx, key, args...))
+	case "SISMEMBER":
+		if len(args) != 1 {
+			return nil, arity(name)
+		}
+		member, err := c.SIsMember(ctx, key, args[0])
+		if member {
+			return int64(1), err
+		}
+		return int64(0), err
+	case "SMEMBERS":
+		return bulksReply(c.SMembers(ctx, key))
+	case "SCARD":
+		return intReply(c.SCard(ctx, key))
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 1 {
+			return nil, arity(name)
+		}
+		ttl, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s is not an integer", ErrSyntax, args[0])
+		}
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		expired, err := c.Expire(ctx, key, time.Duration(ttl)*unit)
+		if expired {
+			return int64(1), err
+		}
+		return int64(0), err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrCommandUnsupported, name)
+}
+
+// del the keys, returns the number of keys deleted
+func (c *inMemoryCache) del(keys []string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	deleted := int64(0)
+	for _, key := range keys {
+		if e, found := c.get(key); found {
+			c.remove(e, EvictedDeleted)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// getString of key, ErrCacheMiss when missing
+func (c *inMemoryCache) getString(key string) (string, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	e, found := c.get(key)
+	if !found {
+		return "", ErrCacheMiss
+	}
+	value, ok := e.value.(string)
+	if !ok {
+		return "", ErrWrongType
+	}
+	c.policy.access(e)
+	return value, nil
+}
+
+// setString of key to the first of args, followed by the NX, XX, EX and PX
+// options. The reply is nil when NX or XX prevent it.
+func (c *inMemoryCache) setString(key string, args []string) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, arity("SET")
+	}
+	value, options := args[0], args[1:]
+	expires := ForEverNeverExpiry
+	var nx, xx bool
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToUpper(options[i]); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 == len(options) {
+				return nil, arity("SET")
+			}
+			i++
+			ttl, err := strconv.ParseInt(options[i], 10, 64)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("%w: invalid expire time %s", ErrSyntax, options[i])
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			expires = time.Duration(ttl) * unit
+		default:
+			return nil, fmt.Errorf("%w: SET option %s", ErrSyntax, options[i])
+		}
+	}
+	if nx && xx {
+		return nil, fmt.Errorf("%w: SET with NX and XX", ErrSyntax)
+	}
+
+	c.mu.Lock()
+	defer c.unlock()
+	_, found := c.get(key)
+	if (nx && found) || (xx && !found) {
+		return nil, nil
+	}
+	if !c.set(key, value, expires) {
+		return nil, ErrNotStored
+	}
+	return "OK", nil
+}
+
+// incr the integer string of key, a missing key counts from 0
+func (c *inMemoryCache) incr(key string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	n := int64(0)
+	e, found := c.get(key)
+	if found {
+		value, ok := e.value.(string)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		var err error
+		if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: %s is not an integer", ErrSyntax, value)
+		}
+	}
+	n++
+	if found {
+		// INCR keeps the expiry of the key
+		e.value = strconv.FormatInt(n, 10)
+		c.resized(key, false)
+		return n, nil
+	}
+	if !c.set(key, strconv.FormatInt(n, 10), ForEverNeverExpiry) {
+		return nil, ErrNotStored
+	}
+	return n, nil
+}
+
+// resized data structure at key after a change, removed once empty like redis
+// does. Called with mu held.
+func (c *inMemoryCache) resized(key string, empty bool) {
+	e, found := c.entries[key]
+	if !found {
+		return
+	}
+	if empty {
+		c.remove(e, EvictedDeleted)
+		return
+	}
+	size := sizeOf(key, e.value)
+	c.bytes += size - e.size
+	e.size = size
+	c.evict()
+}
+
+// structure of type T at key, created with create when missing unless create
+// is nil, the zero T is returned then. Called with mu held.
+func structure[T any](c *inMemoryCache, key string, create func() T) (T, error) {
+	var none T
+	if e, found := c.get(key); found {
+		value, ok := e.value.(T)
+		if !ok {
+			return none, ErrWrongType
+		}
+		c.policy.access(e)
+		return value, nil
+	}
+	if create == nil {
+		return none, nil
+	}
+	value := create()
+	if !c.set(key, value, ForEverNeverExpiry) {
+		return none, ErrNotStored
+	}
+	return value, nil
+}
+
+// sorted members by ascending score, then member
+func (s memorySortedSet) sorted() []ZMember {
+	members := make([]ZMember, 0, len(s))
+	for member, score := range s {
+		members = append(members, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+	return members
+}
+
+// span of n values from start to stop included, as from and to excluded,
+// negative indexes count from the end.
+func span(start int, stop int, n int) (int, int) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
+
+func indexes(start string, stop string) (int, int, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s is not an integer", ErrSyntax, start)
+	}
+	to, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: %s is not an integer", ErrSyntax, stop)
+	}
+	return from, to, nil
+}
+
+func arity(command string) error {
+	return fmt.Errorf("%w: wrong number of arguments for %s", ErrSyntax, command)
+}
+
+// argString formats an argument like redigo does
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	case float64:
+		return strconv.FormatFloat(arg, 'g', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(arg)
+}
+
+func intReply(n int, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return int64(n), nil
+}
+
+// bulkReply is nil for a miss
+func bulkReply(value string, err error) (interface{}, error) {
+	if err == ErrCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+func bulksReply(values []string, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	reply := make([]interface{}, len(values))
+	for i, value := range values {
+		reply[i] = []byte(value)
+	}
+	return reply, nil
+}
+
+func floatReply(value float64, err error) (interface{}, error) {
+	if err == ErrCacheMiss {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatFloat(value, 'g', -1, 64)), nil
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func members(zmembers []ZMember) []string {
+	names := make([]string, len(zmembers))
+	for i, member := range zmembers {
+		names[i] = member.Member
+	}
+	return names
+}
+
+func TestZRangeIndexes(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	if _, err := c.ZAdd(ctx, "scores", ZMember{"d", 4}, ZMember{"b", 2}, ZMember{"a", 1}, ZMember{"c", 3}); err != nil {
+		t.Fatal(err)
+	}
+	ranges := []struct {
+		start, stop int
+		reverse     bool
+		expected    []string
+	}{
+		{0, -1, false, []string{"a", "b", "c", "d"}},
+		{-2, -1, false, []string{"c", "d"}},
+		{1, 100, false, []string{"b", "c", "d"}},
+		{-100, 0, false, []string{"a"}},
+		{3, 1, false, []string{}},
+		{5, 10, false, []string{}},
+		{0, 1, true, []string{"d", "c"}},
+		{-1, -1, true, []string{"a"}},
+	}
+	for _, r := range ranges {
+		var got []ZMember
+		var err error
+		if r.reverse {
+			got, err = c.ZRevRange(ctx, "scores", r.start, r.stop)
+		} else {
+			got, err = c.ZRange(ctx, "scores", r.start, r.stop)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(members(got), r.expected) {
+			t.Errorf("range %d %d reverse %v: expected %v, got %v", r.start, r.stop, r.reverse, r.expected, members(got))
+		}
+	}
+	if rank, err := c.ZRevRank(ctx, "scores", "c"); err != nil || rank != 1 {
+		t.Fatalf("expected rank 1, got %d, %v", rank, err)
+	}
+	if _, err := c.ZRevRank(ctx, "scores", "missing"); err != ErrCacheMiss {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+}
+
+func TestLRangeIndexes(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	if _, err := c.RPush(ctx, "list", "a", "b", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.LPush(ctx, "list", "y", "z"); err != nil || n != 5 {
+		t.Fatalf("expected a length of 5, got %d, %v", n, err)
+	}
+	ranges := []struct {
+		start, stop int
+		expected    []string
+	}{
+		{0, -1, []string{"z", "y", "a", "b", "c"}},
+		{-2, -1, []string{"b", "c"}},
+		{2, 100, []string{"a", "b", "c"}},
+		{-100, 0, []string{"z"}},
+		{-1, -2, []string{}},
+	}
+	for _, r := range ranges {
+		got, err := c.LRange(ctx, "list", r.start, r.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, r.expected) {
+			t.Errorf("range %d %d: expected %v, got %v", r.start, r.stop, r.expected, got)
+		}
+	}
+}
+
+func TestEmptyStructureRemoved(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	_, _ = c.RPush(ctx, "list", "a")
+	if value, err := c.RPop(ctx, "list"); err != nil || value != "a" {
+		t.Fatalf("expected a, got %q, %v", value, err)
+	}
+	if _, err := c.LPop(ctx, "list"); err != ErrCacheMiss {
+		t.Fatalf("expected ErrCacheMiss, got %v", err)
+	}
+	// like redis, the key of an emptied list is another type afterwards
+	if _, err := c.SAdd(ctx, "list", "member"); err != nil {
+		t.Fatalf("emptied list kept: %v", err)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	if _, err := c.HSet(ctx, "hash", map[string]string{"field": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	_ = c.Set("plain", "value", ForEverNeverExpiry)
+
+	checks := map[string]error{}
+	_, checks["LPush"] = c.LPush(ctx, "hash", "value")
+	_, checks["ZAdd"] = c.ZAdd(ctx, "hash", ZMember{"member", 1})
+	_, checks["SMembers"] = c.SMembers(ctx, "hash")
+	_, checks["HGet"] = c.HGet(ctx, "plain", "field")
+	_, checks["GET"] = c.command(ctx, Cmd("GET", "hash"))
+	_, checks["INCR"] = c.command(ctx, Cmd("INCR", "hash"))
+	for name, err := range checks {
+		if !errors.Is(err, ErrWrongType) {
+			t.Errorf("%s expected ErrWrongType, got %v", name, err)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	replies, err := c.Pipeline(ctx, "user",
+		Cmd("HSET", "user", "name", "ann"),
+		Cmd("HINCRBY", "user", "visits", 5),
+		Cmd("LPUSH", "user", "value"),
+		Cmd("HGET", "user", "name"),
+		Cmd("HGET", "user", "missing"),
+		Cmd("ZADD", "top", 1.5, "ann"),
+		Cmd("ZRANGE", "top", 0, -1, "WITHSCORES"),
+	)
+	if !errors.Is(err, ErrWrongType) {
+		t.Fatalf("expected the error of LPUSH, got %v", err)
+	}
+	if n, err := redis.Int(replies[0], nil); err != nil || n != 1 {
+		t.Errorf("HSET replied %v", replies[0])
+	}
+	if n, err := redis.Int64(replies[1], nil); err != nil || n != 5 {
+		t.Errorf("HINCRBY replied %v", replies[1])
+	}
+	if err, ok := replies[2].(error); !ok || !errors.Is(err, ErrWrongType) {
+		t.Errorf("LPUSH replied %v", replies[2])
+	}
+	if name, err := redis.String(replies[3], nil); err != nil || name != "ann" {
+		t.Errorf("HGET replied %v", replies[3])
+	}
+	if replies[4] != nil {
+		t.Errorf("HGET of a missing field replied %v", replies[4])
+	}
+	if top, err := redis.Strings(replies[6], nil); err != nil || !reflect.DeepEqual(top, []string{"ann", "1.5"}) {
+		t.Errorf("ZRANGE replied %v", top)
+	}
+
+	if _, err := c.Pipeline(ctx, "user", Cmd("FLUSHALL", "user")); !errors.Is(err, ErrCommandUnsupported) {
+		t.Fatalf("expected ErrCommandUnsupported, got %v", err)
+	}
+	if _, err := c.Pipeline(ctx, "user", Cmd("HGET", "user")); !errors.Is(err, ErrSyntax) {
+		t.Fatalf("expected ErrSyntax, got %v", err)
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	if reply, err := c.command(ctx, Cmd("SET", "lease", "owner", "NX", "PX", 20)); err != nil || reply != "OK" {
+		t.Fatalf("SET NX replied %v, %v", reply, err)
+	}
+	if reply, err := c.command(ctx, Cmd("SET", "lease", "other", "NX")); err != nil || reply != nil {
+		t.Fatalf("SET NX of an existing key replied %v, %v", reply, err)
+	}
+	if value, err := redis.String(c.command(ctx, Cmd("GET", "lease"))); err != nil || value != "owner" {
+		t.Fatalf("GET replied %q, %v", value, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if reply, err := c.command(ctx, Cmd("GET", "lease")); err != nil || reply != nil {
+		t.Fatalf("expected the key expired, got %v, %v", reply, err)
+	}
+
+	for i := int64(1); i <= 2; i++ {
+		if n, err := redis.Int64(c.command(ctx, Cmd("INCR", "counter"))); err != nil || n != i {
+			t.Fatalf("INCR replied %d, %v", n, err)
+		}
+	}
+	if _, err := c.command(ctx, Cmd("SET", "counter", "value", "EX", "never")); !errors.Is(err, ErrSyntax) {
+		t.Fatalf("expected ErrSyntax, got %v", err)
+	}
+}
+
+func TestEval(t *testing.T) {
+	ctx := context.Background()
+	c := newInMemoryCache(time.Hour)
+	unsupported := NewScript(1, `return redis.call('GET', KEYS[1])`)
+	if _, err := c.Eval(ctx, unsupported, []string{"key"}); err != ErrScriptUnsupported {
+		t.Fatalf("expected ErrScriptUnsupported, got %v", err)
+	}
+
+	double := NewScript(1, `return redis.call('HINCRBY', KEYS[1], ARGV[1], ARGV[2]) * 2`).
+		Emulate(func(ctx context.Context, r RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+			n, err := r.HIncrBy(ctx, keys[0], args[0].(string), args[1].(int64))
+			return 2 * n, err
+		})
+	if reply, err := redis.Int64(c.Eval(ctx, double, []string{"hash"}, "field", int64(3))); err != nil || reply != 6 {
+		t.Fatalf("script replied %d, %v", reply, err)
+	}
+	if _, err := c.Eval(ctx, double, []string{"hash", "other"}, "field", int64(3)); !errors.Is(err, ErrSyntax) {
+		t.Fatalf("expected ErrSyntax for the key count, got %v", err)
+	}
+}
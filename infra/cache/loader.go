@@ -0,0 +1,277 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/sync/singleflight"
+)
+
+var (
+	// ErrNotFound is returned by a loader when there is nothing to load, it is
+	// cached for cache.negativettl and returned by GetOrLoad until then.
+	ErrNotFound = errors.New("not found")
+
+	loads = &loadGroup{
+		inflight: make(map[string]*load),
+	}
+
+	readersMu sync.RWMutex
+	readers   []func(remote bool, key string)
+)
+
+func init() {
+	viper.SetDefault("cache.earlyrefresh", 1.0)
+	viper.SetDefault("cache.negativettl", "30s")
+	viper.SetDefault("cache.loadtimeout", "30s")
+}
+
+// Loader loads the value of key on a cache miss. ctx keeps the values of the
+// caller but not its cancellation, the load is shared with the callers
+// missing the key together. It is cancelled after cache.loadtimeout.
+type Loader func(ctx context.Context, key string) (interface{}, error)
+
+// loadedEntry is stored by GetOrLoad, it records when the value expires and
+// how long it took to load for the early refresh.
+type loadedEntry struct {
+	Data     []byte
+	Expiry   time.Time
+	Delta    time.Duration
+	NotFound bool
+}
+
+// loadGroup collapses the concurrent loads of a key, a load running while the
+// key is invalidated does not store its value.
+type loadGroup struct {
+	group    singleflight.Group
+	mu       sync.Mutex
+	inflight map[string]*load
+	// joined is called once a caller waits for the load of flightKey, set by
+	// the tests only
+	joined func(flightKey string)
+}
+
+type load struct {
+	mu          sync.Mutex
+	invalidated bool
+}
+
+// GetOrLoad is GetOrLoadCtx with context.Background()
+func GetOrLoad(remote bool, key string, ptrValue interface{}, ttl time.Duration, loader Loader) error {
+	return GetOrLoadCtx(context.Background(), remote, key, ptrValue, ttl, loader)
+}
+
+// GetOrLoadCtx gets the value of key into ptrValue, on a miss the value is
+// loaded once however many callers miss concurrently, and cached for ttl. A
+// caller whose ctx is done returns without cancelling the load of the others.
+// A value close to expiry is refreshed early in the background with a
+// probability growing as the expiry nears. A loader returning ErrNotFound is
+// cached for cache.negativettl. A value stored with another SchemaVersion is
+// loaded again.
+func GetOrLoadCtx(ctx context.Context, remote bool, key string, ptrValue interface{}, ttl time.Duration, loader Loader) error {
+	notifyRead(remote, key)
+	entry := &loadedEntry{}
+	if err := GetCtx(ctx, remote, key, entry); err == nil {
+		err = entry.decode(ptrValue)
+		// a value of another schema version is loaded again
+		if errors.Is(err, ErrSchemaVersion) {
+			return loadInto(ctx, remote, key, ptrValue, ttl, loader)
+		}
+		if entry.refreshEarly(time.Now()) {
+			go func() {
+				if _, err := loads.load(detached{ctx}, remote, key, ttl, loader); err != nil && err != ErrNotFound {
+					log.WithFields(log.Fields{
+						"Key":   key,
+						"Error": err,
+					}).Error("Early cache refresh failed")
+				}
+			}()
+		}
+		return err
+	} else if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return loadInto(ctx, remote, key, ptrValue, ttl, loader)
+}
+
+func loadInto(ctx context.Context, remote bool, key string, ptrValue interface{}, ttl time.Duration, loader Loader) error {
+	entry, err := loads.load(ctx, remote, key, ttl, loader)
+	if err != nil {
+		return err
+	}
+	return entry.decode(ptrValue)
+}
+
+// Warm loads key like GetOrLoadCtx on a miss, a cached key is left as is. A
+// loader returning ErrNotFound is not an error.
+func Warm(ctx context.Context, remote bool, key string, ttl time.Duration, loader Loader) error {
+	entry := &loadedEntry{}
+	if err := GetCtx(ctx, remote, key, entry); err == nil {
+		return nil
+	}
+	return Reload(ctx, remote, key, ttl, loader)
+}
+
+// Reload loads key and stores it for ttl whether it is cached or not, before
+// it expires. A loader returning ErrNotFound is not an error.
+func Reload(ctx context.Context, remote bool, key string, ttl time.Duration, loader Loader) error {
+	_, err := loads.load(ctx, remote, key, ttl, loader)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// OnRead calls fn with the keys read by GetOrLoad, to tell the hot keys
+// apart. fn is called on every read and must be quick.
+func OnRead(fn func(remote bool, key string)) {
+	readersMu.Lock()
+	defer readersMu.Unlock()
+	readers = append(readers, fn)
+}
+
+func notifyRead(remote bool, key string) {
+	readersMu.RLock()
+	defer readersMu.RUnlock()
+	for _, fn := range readers {
+		fn(remote, key)
+	}
+}
+
+// Invalidate deletes key before returning, the loads of key in progress do not
+// store their value. A missing key is not an error.
+func Invalidate(remote bool, key string) error {
+	loads.invalidate(remote, key)
+	if err := Delete(remote, key); err != nil && err != ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// load key once for the concurrent callers, on a context detached from their
+// cancellation. A caller returns once its ctx is done, the load goes on for
+// the others.
+func (g *loadGroup) load(ctx context.Context, remote bool, key string, ttl time.Duration, loader Loader) (*loadedEntry, error) {
+	flightKey := strconv.FormatBool(remote) + ":" + key
+	results := g.group.DoChan(flightKey, func() (interface{}, error) {
+		flight := &load{}
+		g.mu.Lock()
+		g.inflight[flightKey] = flight
+		g.mu.Unlock()
+		defer func() {
+			g.mu.Lock()
+			if g.inflight[flightKey] == flight {
+				delete(g.inflight, flightKey)
+			}
+			g.mu.Unlock()
+		}()
+
+		loadCtx, cancel := context.WithTimeout(detached{ctx}, viper.GetDuration("cache.loadtimeout"))
+		defer cancel()
+		start := time.Now()
+		value, err := loader(loadCtx, key)
+		entry := &loadedEntry{Delta: time.Since(start)}
+		switch {
+		case err == ErrNotFound:
+			entry.NotFound = true
+			ttl = negativeTTL()
+		case err != nil:
+			return nil, err
+		default:
+			if entry.Data, err = serialize(defaultCodec(), value); err != nil {
+				return nil, err
+			}
+		}
+		if ttl > 0 {
+			entry.Expiry = time.Now().Add(ttl)
+		}
+
+		// Invalidate deletes after marking the load, store before or not at all
+		flight.mu.Lock()
+		defer flight.mu.Unlock()
+		if !flight.invalidated {
+			if err := SetCtx(loadCtx, remote, key, *entry, ttl); err != nil {
+				log.WithFields(log.Fields{
+					"Key":   key,
+					"Error": err,
+				}).Error("Storing loaded value failed")
+			}
+		}
+		return entry, nil
+	})
+	if g.joined != nil {
+		g.joined(flightKey)
+	}
+	select {
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.(*loadedEntry), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detached keeps the values of a context, like its trace, but neither its
+// deadline nor its cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (g *loadGroup) invalidate(remote bool, key string) {
+	flightKey := strconv.FormatBool(remote) + ":" + key
+	g.mu.Lock()
+	flight, ok := g.inflight[flightKey]
+	g.mu.Unlock()
+	if ok {
+		flight.mu.Lock()
+		flight.invalidated = true
+		flight.mu.Unlock()
+	}
+	g.group.Forget(flightKey)
+}
+
+// refreshEarly tells whether to refresh the entry before it expires, the
+// probability grows as the expiry nears and with the time taken to load.
+func (entry *loadedEntry) refreshEarly(now time.Time) bool {
+	if entry.Expiry.IsZero() || entry.Delta <= 0 {
+		return false
+	}
+	beta := viper.GetFloat64("cache.earlyrefresh")
+	if beta <= 0 {
+		return false
+	}
+	early := time.Duration(float64(entry.Delta) * beta * -math.Log(1-rand.Float64()))
+	return !now.Add(early).Before(entry.Expiry)
+}
+
+func (entry *loadedEntry) decode(ptrValue interface{}) error {
+	if entry.NotFound {
+		return ErrNotFound
+	}
+	return deserialize(entry.Data, ptrValue)
+}
+
+func negativeTTL() time.Duration {
+	return viper.GetDuration("cache.negativettl")
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useMemoryCache as the local cache of the test, without a remote one
+func useMemoryCache(t *testing.T) *inMemoryCache {
+	c := newInMemoryCache(time.Hour)
+	backends.mu.Lock()
+	previousLocal, previousRemote := backends.local, backends.remote
+	backends.local, backends.remote = c, nil
+	backends.mu.Unlock()
+	t.Cleanup(func() {
+		backends.mu.Lock()
+		backends.local, backends.remote = previousLocal, previousRemote
+		backends.mu.Unlock()
+	})
+	return c
+}
+
+func TestGetOrLoadCollapsesLoads(t *testing.T) {
+	useMemoryCache(t)
+	joined := make(chan struct{}, 10)
+	loads.joined = func(flightKey string) { joined <- struct{}{} }
+	t.Cleanup(func() { loads.joined = nil })
+
+	var calls int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(entered)
+		<-release
+		return int64(42), nil
+	}
+
+	results := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			var value int64
+			err := GetOrLoadCtx(context.Background(), false, "collapsed", &value, time.Minute, loader)
+			if err == nil && value != 42 {
+				t.Errorf("loaded %d", value)
+			}
+			results <- err
+		}()
+	}
+	// every caller waits for the load before it is released
+	<-entered
+	for i := 0; i < 10; i++ {
+		<-joined
+	}
+	close(release)
+	for i := 0; i < 10; i++ {
+		if err := <-results; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 load, got %d", calls)
+	}
+
+	var value int64
+	if err := GetOrLoad(false, "collapsed", &value, time.Minute, loader); err != nil || value != 42 {
+		t.Fatalf("cached value %d, %v", value, err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected the cached value, got %d loads", calls)
+	}
+}
+
+func TestGetOrLoadCallerCancelled(t *testing.T) {
+	useMemoryCache(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return "loaded", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		var value string
+		first <- GetOrLoadCtx(cancelled, false, "shared", &value, time.Minute, loader)
+	}()
+	<-started
+	second := make(chan string, 1)
+	go func() {
+		var value string
+		if err := GetOrLoadCtx(context.Background(), false, "shared", &value, time.Minute, loader); err != nil {
+			t.Error(err)
+		}
+		second <- value
+	}()
+
+	// the first caller gives up, the load goes on for the second one
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	close(release)
+	if value := <-second; value != "loaded" {
+		t.Fatalf("second caller got %q", value)
+	}
+}
+
+func TestGetOrLoadNotFound(t *testing.T) {
+	useMemoryCache(t)
+	var loads int32
+	loader := func(ctx context.Context, key string) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		return nil, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		var value string
+		if err := GetOrLoad(false, "missing", &value, time.Minute, loader); err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("expected the miss to be cached, got %d loads", loads)
+	}
+}
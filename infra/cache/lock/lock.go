@@ -0,0 +1,251 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"go-microservice/infra/cache"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	ErrNotAcquired = errors.New("Lock is held by another owner")
+	ErrLockLost    = errors.New("Lock is no longer held")
+
+	locks = cache.NewNamespace("lock")
+)
+
+// acquireScript sets the lease of the owner unless it is held, then takes the
+// next token of the fence. The fence starts from the time it is created so
+// that a fence evicted from the cache does not give the tokens of the
+// previous one again.
+var acquireScript = cache.NewScript(2, `
+if not redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+	return 0
+end
+redis.call('SET', KEYS[2], ARGV[3], 'NX')
+return redis.call('INCR', KEYS[2])
+`).Emulate(func(ctx context.Context, r cache.RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+	set, err := command(ctx, r, keys[0], "SET", args[0], "NX", "PX", args[1])
+	if err != nil || set == nil {
+		return int64(0), err
+	}
+	if _, err := command(ctx, r, keys[1], "SET", args[2], "NX"); err != nil {
+		return nil, err
+	}
+	return command(ctx, r, keys[1], "INCR")
+})
+
+// renewScript extends the lease of the owner
+var renewScript = cache.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`).Emulate(func(ctx context.Context, r cache.RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+	if owned, err := owns(ctx, r, keys[0], args[0]); !owned || err != nil {
+		return int64(0), err
+	}
+	renewed, err := r.Expire(ctx, keys[0], time.Duration(args[1].(int64))*time.Millisecond)
+	if renewed {
+		return int64(1), err
+	}
+	return int64(0), err
+})
+
+// releaseScript deletes the lease of the owner
+var releaseScript = cache.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`).Emulate(func(ctx context.Context, r cache.RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+	if owned, err := owns(ctx, r, keys[0], args[0]); !owned || err != nil {
+		return int64(0), err
+	}
+	return command(ctx, r, keys[0], "DEL")
+})
+
+// Lock is a lease on a name, held until released or until its ttl elapses
+// without being renewed. A holder may lose the lease while paused, the
+// resources it guards should reject the writes of a lower Token than the last
+// one they saw.
+type Lock struct {
+	remote bool
+	name   string
+	owner  string
+	token  uint64
+}
+
+// Acquire the lock name for ttl, on the remote cache to be shared by the
+// instances, returns ErrNotAcquired when held. The cache must offer
+// cache.Extended, like redis and the in-memory cache.
+func Acquire(remote bool, name string, ttl time.Duration) (*Lock, error) {
+	return acquire(context.Background(), remote, name, ttl)
+}
+
+func acquire(ctx context.Context, remote bool, name string, ttl time.Duration) (*Lock, error) {
+	owner := newOwner()
+	token, err := redis.Uint64(eval(ctx, remote, acquireScript, []string{leaseKey(name), fenceKey(name)},
+		owner, milliseconds(ttl), time.Now().UnixNano()))
+	if err != nil {
+		return nil, err
+	}
+	if token == 0 {
+		return nil, ErrNotAcquired
+	}
+	return &Lock{
+		remote: remote,
+		name:   name,
+		owner:  owner,
+		token:  token,
+	}, nil
+}
+
+// AcquireWait retries to acquire the lock until ctx is done
+func AcquireWait(ctx context.Context, remote bool, name string, ttl time.Duration) (*Lock, error) {
+	backoff := 10 * time.Millisecond
+	for {
+		l, err := acquire(ctx, remote, name, ttl)
+		if err != ErrNotAcquired {
+			return l, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff < ttl/4 {
+			backoff *= 2
+		}
+	}
+}
+
+// Leader runs job while holding the lock name, so that one instance runs it at
+// a time. The lock is renewed every ttl/3, the ctx of job is cancelled when
+// it is lost. Returns when ctx is done or job returns.
+func Leader(ctx context.Context, remote bool, name string, ttl time.Duration, job func(ctx context.Context) error) error {
+	l, err := AcquireWait(ctx, remote, name, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := l.Release(); err != nil && err != ErrLockLost {
+			log.WithFields(log.Fields{
+				"Lock":  name,
+				"Error": err,
+			}).Error("Releasing lock failed")
+		}
+	}()
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Renew(ttl); err != nil {
+					log.WithFields(log.Fields{
+						"Lock":  name,
+						"Error": err,
+					}).Error("Renewing lock failed, stopping the job")
+					cancel()
+					return
+				}
+			case <-jobCtx.Done():
+				return
+			}
+		}
+	}()
+	return job(jobCtx)
+}
+
+// Token of the lease, greater than the tokens of the previous leases
+func (l *Lock) Token() uint64 {
+	return l.token
+}
+
+// Renew the lease for ttl, returns ErrLockLost when it is held by another
+// owner or expired.
+func (l *Lock) Renew(ttl time.Duration) error {
+	renewed, err := redis.Bool(eval(context.Background(), l.remote, renewScript, []string{leaseKey(l.name)}, l.owner, milliseconds(ttl)))
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Release the lease, returns ErrLockLost when it is held by another owner or
+// expired.
+func (l *Lock) Release() error {
+	released, err := redis.Bool(eval(context.Background(), l.remote, releaseScript, []string{leaseKey(l.name)}, l.owner))
+	if err != nil {
+		return err
+	}
+	if !released {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// leaseKey and fenceKey of the lock name share a {tag}, a script takes keys of
+// one redis cluster node.
+func leaseKey(name string) string {
+	return locks.Key("{" + name + "}")
+}
+
+func fenceKey(name string) string {
+	return locks.Key("{"+name+"}", "fence")
+}
+
+// eval script on the extended data structures of the local or remote cache
+func eval(ctx context.Context, remote bool, script *cache.Script, keys []string, args ...interface{}) (interface{}, error) {
+	extended, err := cache.Extended(remote)
+	if err != nil {
+		return nil, err
+	}
+	return extended.Eval(ctx, script, keys, args...)
+}
+
+// command of an emulated script on key, its reply is that of redigo
+func command(ctx context.Context, r cache.RedisExtended, key string, name string, args ...interface{}) (interface{}, error) {
+	replies, err := r.Pipeline(ctx, key, cache.Cmd(name, append([]interface{}{key}, args...)...))
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// owns tells whether the lease at key is that of owner
+func owns(ctx context.Context, r cache.RedisExtended, key string, owner interface{}) (bool, error) {
+	current, err := redis.String(command(ctx, r, key, "GET"))
+	if err == redis.ErrNil {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return current == owner, nil
+}
+
+// milliseconds of ttl, at least one as redis expects
+func milliseconds(ttl time.Duration) int64 {
+	if ms := int64(ttl / time.Millisecond); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+func newOwner() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
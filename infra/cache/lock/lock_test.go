@@ -0,0 +1,195 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"go-microservice/infra/cache"
+	"go-microservice/infra/server"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestMain runs the tests on the in-memory cache, without a remote one
+func TestMain(m *testing.M) {
+	viper.Set("cache.remote", "none")
+	services, err := server.GetServices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range services {
+		if service.Name == "caches" {
+			if err := service.Instance.Init(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// flush the counts and buckets of the previous runs
+func flush(t *testing.T) {
+	if err := cache.Flush(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireExclusive(t *testing.T) {
+	first, err := Acquire(false, "exclusive", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(false, "exclusive", time.Minute); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Release(); err != ErrLockLost {
+		t.Fatalf("expected ErrLockLost releasing twice, got %v", err)
+	}
+
+	second, err := Acquire(false, "exclusive", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+	if second.Token() <= first.Token() {
+		t.Fatalf("token %d not greater than the previous %d", second.Token(), first.Token())
+	}
+}
+
+func TestAcquireConcurrently(t *testing.T) {
+	var holders, held int32
+	var mu sync.Mutex
+	var tokens []uint64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			l, err := AcquireWait(ctx, false, "concurrent", time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if atomic.AddInt32(&holders, 1) != 1 {
+				t.Error("lock held twice")
+			}
+			mu.Lock()
+			tokens = append(tokens, l.Token())
+			mu.Unlock()
+			atomic.AddInt32(&held, 1)
+			atomic.AddInt32(&holders, -1)
+			if err := l.Release(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if held != 20 {
+		t.Fatalf("expected 20 leases, got %d", held)
+	}
+	// the tokens grow in the order of the leases
+	for i := 1; i < len(tokens); i++ {
+		if tokens[i] <= tokens[i-1] {
+			t.Fatalf("tokens not growing: %v", tokens)
+		}
+	}
+}
+
+func TestLostLease(t *testing.T) {
+	first, err := Acquire(false, "lost", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	second, err := Acquire(false, "lost", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Release()
+
+	// the previous holder neither extends nor deletes the lease of the new one
+	if err := first.Renew(time.Minute); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost renewing, got %v", err)
+	}
+	if err := first.Release(); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost releasing, got %v", err)
+	}
+	if err := second.Renew(time.Minute); err != nil {
+		t.Fatalf("renewing the current lease failed: %v", err)
+	}
+	if _, err := Acquire(false, "lost", time.Minute); err != ErrNotAcquired {
+		t.Fatalf("expected ErrNotAcquired, got %v", err)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	flush(t)
+	limiter := NewSlidingWindow(false, "window", 10, time.Hour)
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := limiter.Allow("user")
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Fatalf("expected 10 actions allowed, got %d", allowed)
+	}
+	if ok, err := limiter.Allow("other"); !ok || err != nil {
+		t.Fatalf("another key denied: %v", err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	flush(t)
+	limiter := NewTokenBucket(false, "bucket", 5, 200*time.Millisecond)
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := limiter.Allow("user")
+			if err != nil {
+				t.Error(err)
+			}
+			if ok {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 5 {
+		t.Fatalf("expected a burst of 5 actions, got %d", allowed)
+	}
+
+	// refilled by one token every 200ms, counted from the last take of the
+	// burst, the burst refills none unless it lasts 200ms
+	time.Sleep(250 * time.Millisecond)
+	if ok, err := limiter.Allow("user"); !ok || err != nil {
+		t.Fatalf("refilled token denied: %v", err)
+	}
+	if ok, err := limiter.Allow("user"); ok || err != nil {
+		t.Fatalf("expected an empty bucket, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,173 @@
+package lock
+
+import (
+	"context"
+	"go-microservice/infra/cache"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+var limits = cache.NewNamespace("ratelimit")
+
+// windowScript counts an action in the current window unless the estimate of
+// the sliding window, weighting the previous window by ARGV[1], reaches the
+// limit ARGV[2]. A denied action is not counted.
+var windowScript = cache.NewScript(2, `
+local previous = tonumber(redis.call('GET', KEYS[1]) or '0')
+local current = tonumber(redis.call('GET', KEYS[2]) or '0')
+if previous * tonumber(ARGV[1]) + current + 1 > tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+return 1
+`).Emulate(func(ctx context.Context, r cache.RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+	counts := make([]float64, len(keys))
+	for i, key := range keys {
+		count, err := redis.Float64(command(ctx, r, key, "GET"))
+		if err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+		counts[i] = count
+	}
+	if counts[0]*args[0].(float64)+counts[1]+1 > float64(args[1].(uint64)) {
+		return int64(0), nil
+	}
+	if _, err := command(ctx, r, keys[1], "INCR"); err != nil {
+		return nil, err
+	}
+	if _, err := r.Expire(ctx, keys[1], time.Duration(args[2].(int64))*time.Millisecond); err != nil {
+		return nil, err
+	}
+	return int64(1), nil
+})
+
+// bucketScript refills the bucket of capacity ARGV[1] by one token every
+// ARGV[2] microseconds up to now ARGV[3], then takes a token unless it is
+// empty. A bucket left alone long enough to be full again is not kept.
+var bucketScript = cache.NewScript(1, `
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or capacity
+local updated = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(now - updated, 0) / refill)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', ARGV[3])
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) * refill / 1000) + 1000)
+return allowed
+`).Emulate(func(ctx context.Context, r cache.RedisExtended, keys []string, args []interface{}) (interface{}, error) {
+	capacity, refill, now := args[0].(float64), float64(args[1].(int64)), args[2].(int64)
+	state, err := r.HGetAll(ctx, keys[0])
+	if err != nil {
+		return nil, err
+	}
+	tokens, updated := capacity, float64(now)
+	if value, ok := state["tokens"]; ok {
+		if tokens, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, err
+		}
+	}
+	if value, ok := state["updated"]; ok {
+		if updated, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, err
+		}
+	}
+	tokens = math.Min(capacity, tokens+math.Max(float64(now)-updated, 0)/refill)
+	allowed := int64(0)
+	if tokens >= 1 {
+		tokens--
+		allowed = 1
+	}
+	_, err = r.HSet(ctx, keys[0], map[string]string{
+		"tokens":  strconv.FormatFloat(tokens, 'g', -1, 64),
+		"updated": strconv.FormatInt(now, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ttl := time.Duration(math.Ceil((capacity-tokens)*refill/1000))*time.Millisecond + time.Second
+	if _, err := r.Expire(ctx, keys[0], ttl); err != nil {
+		return nil, err
+	}
+	return allowed, nil
+})
+
+// Limiter allows a number of actions per key, like a user, over time
+type Limiter interface {
+	Allow(key string) (bool, error)
+}
+
+// SlidingWindow allows limit actions per key in any window, estimating the
+// actions of the sliding window from the counts of the current and previous
+// fixed windows.
+type SlidingWindow struct {
+	remote bool
+	name   string
+	limit  uint64
+	window time.Duration
+}
+
+// TokenBucket allows bursts of capacity actions per key, refilled by one
+// every refill.
+type TokenBucket struct {
+	remote   bool
+	name     string
+	capacity float64
+	refill   time.Duration
+}
+
+// NewSlidingWindow limiter name allowing limit actions per window
+func NewSlidingWindow(remote bool, name string, limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		remote: remote,
+		name:   name,
+		limit:  uint64(limit),
+		window: window,
+	}
+}
+
+// NewTokenBucket limiter name allowing bursts of capacity, refilled by one
+// every refill.
+func NewTokenBucket(remote bool, name string, capacity int, refill time.Duration) *TokenBucket {
+	return &TokenBucket{
+		remote:   remote,
+		name:     name,
+		capacity: float64(capacity),
+		refill:   refill,
+	}
+}
+
+// Allow counts an action of key, unless the window is full
+func (l *SlidingWindow) Allow(key string) (bool, error) {
+	now := time.Now()
+	index := now.UnixNano() / int64(l.window)
+	elapsed := float64(now.UnixNano()%int64(l.window)) / float64(l.window)
+	keys := []string{l.key(key, index-1), l.key(key, index)}
+	return redis.Bool(eval(context.Background(), l.remote, windowScript, keys, 1-elapsed, l.limit, milliseconds(2*l.window)))
+}
+
+// key of the count of a window, the windows of key share a {tag} for the
+// script to take them on one redis cluster node.
+func (l *SlidingWindow) key(key string, index int64) string {
+	return limits.Key("{"+l.name+":"+key+"}", strconv.FormatInt(index, 10))
+}
+
+// Allow takes a token of the bucket of key, unless it is empty. The bucket is
+// updated by one script so that the instances do not take the same token.
+func (l *TokenBucket) Allow(key string) (bool, error) {
+	refill := int64(l.refill / time.Microsecond)
+	if refill < 1 {
+		refill = 1
+	}
+	now := time.Now().UnixNano() / int64(time.Microsecond)
+	return redis.Bool(eval(context.Background(), l.remote, bucketScript, []string{limits.Key(l.name, key)}, l.capacity, refill, now))
+}
@@ -1,8 +1,9 @@
 package cache
 
 import (
+	"context"
 	"errors"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -12,80 +13,172 @@ import (
 
 type memcachedCache struct {
 	client            *memcache.Client
+	codec             Codec
 	defaultExpiration time.Duration
 }
 
-type itemMapGetter map[string]*memcache.Item
+type itemMapGetter struct {
+	cache *memcachedCache
+	items map[string]*memcache.Item
+}
+
+// taggedItem is stored by SetWithTags with the generations of its tags, it is
+// a miss once one of them is invalidated. Memcache can neither list nor flush
+// keys, invalidating a tag increments its generation instead.
+type taggedItem struct {
+	Generations map[string]uint64
+	Data        []byte
+}
+
+// flagTagged marks the items holding a taggedItem
+const flagTagged = 1
+
+var ErrNoMemcacheHost = errors.New("no memcache host configured")
 
 func init() {
-	// defaultExpiration := time.Hour
-	// remoteCache = &memcachedCache{
-	// 	defaultExpiration: defaultExpiration,
-	// }
-	// server.RegisterService(remoteCache.(*memcachedCache), server.Low)
+	RegisterBackend("memcache", func() (Cache, error) {
+		c := &memcachedCache{
+			defaultExpiration: defaultTTL(),
+		}
+		return c, c.Init()
+	}, "memcache")
 }
 
 func (c *memcachedCache) Init() (err error) {
 	viper.SetDefault("memcache", "")
-	hosts := strings.Split(viper.Get("memcache").(string), ",")
+	hosts := splitHosts(viper.GetString("memcache"))
 	if len(hosts) == 0 {
-		log.Fatalln("No host configured")
+		return ErrNoMemcacheHost
+	}
+	if c.codec, err = codecNamed(viper.GetString("cache.codec")); err != nil {
+		return err
+	}
+	servers := &memcache.ServerList{}
+	if err := servers.SetServers(hosts...); err != nil {
+		return err
 	}
 	log.Infof("Initialised memcache hosts : %v", hosts)
-	c.client = memcache.New(hosts...)
+	c.client = memcache.NewFromSelector(servers)
+	c.client.MaxIdleConns = poolSize()
 	return nil
 }
 
-func (c *memcachedCache) OnConfig() {
-}
-
 func newMemcachedCache(hostList []string, defaultExpiration time.Duration) *memcachedCache {
 	return &memcachedCache{
-		memcache.New(hostList...),
-		defaultExpiration,
+		client:            memcache.New(hostList...),
+		codec:             Gob,
+		defaultExpiration: defaultExpiration,
 	}
 }
 
 func (c *memcachedCache) Set(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Set, key, value, expires)
+	return c.SetCtx(context.Background(), key, value, expires)
+}
+
+// SetCtx is Set within the deadline of ctx
+func (c *memcachedCache) SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Set, key, value, expires)
 }
 
 func (c *memcachedCache) Add(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Add, key, value, expires)
+	return c.AddCtx(context.Background(), key, value, expires)
+}
+
+// AddCtx is Add within the deadline of ctx
+func (c *memcachedCache) AddCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Add, key, value, expires)
 }
 
 func (c *memcachedCache) Replace(key string, value interface{}, expires time.Duration) error {
-	return c.invoke((*memcache.Client).Replace, key, value, expires)
+	return c.ReplaceCtx(context.Background(), key, value, expires)
+}
+
+// ReplaceCtx is Replace within the deadline of ctx
+func (c *memcachedCache) ReplaceCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	return c.invoke(ctx, (*memcache.Client).Replace, key, value, expires)
 }
 
 func (c *memcachedCache) Get(key string, ptrValue interface{}) error {
-	item, err := c.client.Get(key)
+	return c.GetCtx(context.Background(), key, ptrValue)
+}
+
+// GetCtx is Get within the deadline of ctx
+func (c *memcachedCache) GetCtx(ctx context.Context, key string, ptrValue interface{}) error {
+	var data []byte
+	err := await(ctx, func() error {
+		item, err := c.client.Get(key)
+		if err != nil {
+			return convertMemcacheError(err)
+		}
+		data, err = c.payload(item)
+		return err
+	})
 	if err != nil {
-		return convertMemcacheError(err)
+		return err
 	}
-	return deserialize(item.Value, ptrValue)
+	return deserialize(data, ptrValue)
 }
 
 func (c *memcachedCache) GetMulti(keys ...string) (Getter, error) {
-	items, err := c.client.GetMulti(keys)
+	return c.GetMultiCtx(context.Background(), keys...)
+}
+
+// GetMultiCtx is GetMulti within the deadline of ctx
+func (c *memcachedCache) GetMultiCtx(ctx context.Context, keys ...string) (Getter, error) {
+	var items map[string]*memcache.Item
+	err := await(ctx, func() (err error) {
+		items, err = c.client.GetMulti(keys)
+		return convertMemcacheError(err)
+	})
 	if err != nil {
-		return nil, convertMemcacheError(err)
+		return nil, err
 	}
-	return itemMapGetter(items), nil
+	return itemMapGetter{cache: c, items: items}, nil
 }
 
 func (c *memcachedCache) Delete(key string) error {
-	return convertMemcacheError(c.client.Delete(key))
+	return c.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx is Delete within the deadline of ctx
+func (c *memcachedCache) DeleteCtx(ctx context.Context, key string) error {
+	return await(ctx, func() error {
+		return convertMemcacheError(c.client.Delete(key))
+	})
 }
 
 func (c *memcachedCache) Increment(key string, delta uint64) (newValue uint64, err error) {
-	newValue, err = c.client.Increment(key, delta)
-	return newValue, convertMemcacheError(err)
+	return c.IncrementCtx(context.Background(), key, delta)
+}
+
+// IncrementCtx is Increment within the deadline of ctx
+func (c *memcachedCache) IncrementCtx(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	var value uint64
+	err = await(ctx, func() (err error) {
+		value, err = c.client.Increment(key, delta)
+		return convertMemcacheError(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
 }
 
 func (c *memcachedCache) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	newValue, err = c.client.Decrement(key, delta)
-	return newValue, convertMemcacheError(err)
+	return c.DecrementCtx(context.Background(), key, delta)
+}
+
+// DecrementCtx is Decrement within the deadline of ctx
+func (c *memcachedCache) DecrementCtx(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	var value uint64
+	err = await(ctx, func() (err error) {
+		value, err = c.client.Decrement(key, delta)
+		return convertMemcacheError(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
 }
 
 func (c *memcachedCache) Flush() error {
@@ -94,34 +187,174 @@ func (c *memcachedCache) Flush() error {
 	return err
 }
 
-func (c *memcachedCache) invoke(f func(*memcache.Client, *memcache.Item) error,
+func (c *memcachedCache) FlushCtx(ctx context.Context) error {
+	return c.Flush()
+}
+
+func (c *memcachedCache) invoke(ctx context.Context, f func(*memcache.Client, *memcache.Item) error,
 	key string, value interface{}, expires time.Duration) error {
 
+	b, err := serialize(c.codec, value)
+	if err != nil {
+		return err
+	}
+	return await(ctx, func() error {
+		return convertMemcacheError(f(c.client, &memcache.Item{
+			Key:        key,
+			Value:      b,
+			Expiration: c.expiration(expires),
+		}))
+	})
+}
+
+// await f until ctx is done. The memcache client cannot be interrupted, f
+// carries on in the background until the client Timeout, its result dropped.
+func await(ctx context.Context, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil {
+		return f()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// expiration of the items set with expires, 0 never expires
+func (c *memcachedCache) expiration(expires time.Duration) int32 {
 	switch expires {
 	case DefaultExpiryTime:
 		expires = c.defaultExpiration
 	case ForEverNeverExpiry:
 		expires = time.Duration(0)
 	}
+	return int32(expires / time.Second)
+}
 
-	b, err := serialize(value)
+// SetWithTags stores the value with the current generations of tags
+func (c *memcachedCache) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	generations, err := c.generations(tags, true)
 	if err != nil {
 		return err
 	}
-	return convertMemcacheError(f(c.client, &memcache.Item{
+	data, err := serialize(c.codec, value)
+	if err != nil {
+		return err
+	}
+	b, err := encode(Gob, taggedItem{Generations: generations, Data: data})
+	if err != nil {
+		return err
+	}
+	return convertMemcacheError(c.client.Set(&memcache.Item{
 		Key:        key,
 		Value:      b,
-		Expiration: int32(expires / time.Second),
+		Flags:      flagTagged,
+		Expiration: c.expiration(expires),
 	}))
 }
 
+// InvalidateTag increments the generation of each tag, the items set with the
+// previous generation are misses from now on.
+func (c *memcachedCache) InvalidateTag(tags ...string) error {
+	for _, tag := range tags {
+		_, err := c.client.Increment(tagKey(tag), 1)
+		// a generation evicted already invalidated its items
+		if err != nil && err != memcache.ErrCacheMiss {
+			return convertMemcacheError(err)
+		}
+	}
+	return nil
+}
+
+// generations of tags, the missing ones are created when create is set
+// otherwise they are left out.
+func (c *memcachedCache) generations(tags []string, create bool) (map[string]uint64, error) {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		keys = append(keys, tagKey(tag))
+	}
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, convertMemcacheError(err)
+	}
+
+	generations := make(map[string]uint64)
+	for i, tag := range tags {
+		if item, ok := items[keys[i]]; ok {
+			generation, err := strconv.ParseUint(string(item.Value), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			generations[tag] = generation
+			continue
+		}
+		if !create {
+			continue
+		}
+		// a generation created again must not match the items of the evicted one
+		generation := uint64(time.Now().UnixNano())
+		err := c.client.Add(&memcache.Item{
+			Key:   keys[i],
+			Value: []byte(strconv.FormatUint(generation, 10)),
+		})
+		if err == memcache.ErrNotStored {
+			return c.generations(tags, create)
+		} else if err != nil {
+			return nil, convertMemcacheError(err)
+		}
+		generations[tag] = generation
+	}
+	return generations, nil
+}
+
+func (c *memcachedCache) decode(item *memcache.Item, ptrValue interface{}) error {
+	data, err := c.payload(item)
+	if err != nil {
+		return err
+	}
+	return deserialize(data, ptrValue)
+}
+
+// payload of the item, a tagged item invalidated since it was set is a miss
+func (c *memcachedCache) payload(item *memcache.Item) ([]byte, error) {
+	if item.Flags&flagTagged == 0 {
+		return item.Value, nil
+	}
+	tagged := taggedItem{}
+	if err := deserialize(item.Value, &tagged); err != nil {
+		return nil, err
+	}
+	tags := make([]string, 0, len(tagged.Generations))
+	for tag := range tagged.Generations {
+		tags = append(tags, tag)
+	}
+	generations, err := c.generations(tags, false)
+	if err != nil {
+		return nil, err
+	}
+	for tag, generation := range tagged.Generations {
+		if current, ok := generations[tag]; !ok || current != generation {
+			return nil, ErrCacheMiss
+		}
+	}
+	return tagged.Data, nil
+}
+
 func (g itemMapGetter) Get(key string, ptrValue interface{}) error {
-	item, ok := g[key]
+	item, ok := g.items[key]
 	if !ok {
 		return ErrCacheMiss
 	}
 
-	return deserialize(item.Value, ptrValue)
+	return g.cache.decode(item, ptrValue)
 }
 
 func convertMemcacheError(err error) error {
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"go-microservice/infra/metrics"
+)
+
+// observedGetter counts the hits and misses of keys read from GetMulti
+type observedGetter struct {
+	Getter
+	cache Cache
+}
+
+func (g *observedGetter) Get(key string, ptrValue interface{}) error {
+	err := g.Getter.Get(key, ptrValue)
+	observe(g.cache, "get", err)
+	return err
+}
+
+func observe(c Cache, operation string, err error) {
+	result := "ok"
+	switch err {
+	case nil:
+		if operation == "get" {
+			result = "hit"
+		}
+	case ErrCacheMiss:
+		result = "miss"
+	case ErrNotStored:
+		result = "not_stored"
+	default:
+		result = "error"
+	}
+	metrics.CacheOperations.WithLabelValues(backendName(c), operation, result).Inc()
+}
+
+func backendName(c Cache) string {
+	switch c.(type) {
+	case *inMemoryCache:
+		return "inMemory"
+	case *tieredCache:
+		return "near"
+	case *redisCache:
+		return "redis"
+	case *memcachedCache:
+		return "memcache"
+	case noCache:
+		return "none"
+	}
+	return "unknown"
+}
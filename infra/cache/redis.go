@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -10,113 +14,214 @@ import (
 )
 
 type redisCache struct {
-	pool              *redis.Pool
+	topology          redisTopology
+	codec             Codec
 	defaultExpiration time.Duration
 }
 
 type redisItemMapGetter map[string][]byte
 
+// redisTopology routes the keys to the redis nodes serving them
+type redisTopology interface {
+	// conn to the node serving key
+	conn(ctx context.Context, key string) (redis.Conn, error)
+	// group the keys which can be read together by one MGET
+	group(keys []string) [][]string
+	// masters are the pools of every node holding keys
+	masters() []*redis.Pool
+	close() error
+}
+
+var (
+	ErrNoRedisHost         = errors.New("no redis host configured")
+	ErrUnknownRedisMode    = errors.New("unknown redis mode")
+	ErrRedisMasterNotFound = errors.New("redis master not found")
+)
+
 func init() {
-	// defaultExpiration := time.Hour
-	// remoteCache = &redisCache{
-	// 	defaultExpiration: defaultExpiration,
-	// }
-	// server.RegisterService(remoteCache.(*redisCache), server.Low)
+	RegisterBackend("redis", func() (Cache, error) {
+		c := &redisCache{
+			defaultExpiration: defaultTTL(),
+		}
+		return c, c.Init()
+	}, "redis", "redispassword", "redismode", "redismaster")
 }
 
+// Init connects to the redis hosts, in redismode shard the keys are spread
+// over the hosts by consistent hashing, in sentinel the hosts are the
+// sentinels of the redismaster and in cluster the seeds of the cluster.
 func (c *redisCache) Init() (err error) {
 	viper.SetDefault("redis", "")
-	hosts := strings.Split(viper.Get("redis").(string), ",")
-	if len(hosts) == 0 || len(hosts) > 1 {
-		log.Fatalln("None or more than one host configured")
-	}
 	viper.SetDefault("redispassword", "")
-	password := viper.Get("redispassword").(string)
-	log.Infof("Initialised redis hosts : %s", hosts[0])
-	c.pool = newRedisCache(hosts[0], password, c.defaultExpiration)
+	viper.SetDefault("redismode", "shard")
+	viper.SetDefault("redismaster", "mymaster")
+	hosts := splitHosts(viper.GetString("redis"))
+	if len(hosts) == 0 {
+		return ErrNoRedisHost
+	}
+	if c.codec, err = codecNamed(viper.GetString("cache.codec")); err != nil {
+		return err
+	}
+	password := viper.GetString("redispassword")
+	mode := viper.GetString("redismode")
+	switch mode {
+	case "shard":
+		c.topology = newShardedRedis(hosts, password)
+	case "sentinel":
+		c.topology = newSentinelRedis(hosts, viper.GetString("redismaster"), password)
+	case "cluster":
+		c.topology = newClusterRedis(hosts, password)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownRedisMode, mode)
+	}
+	log.WithFields(log.Fields{
+		"Mode":  mode,
+		"Hosts": hosts,
+	}).Info("Initialised redis")
 	return nil
 }
 
-func (c *redisCache) OnConfig() {
+func (c *redisCache) CheckHealth(ctx context.Context) error {
+	for _, pool := range c.topology.masters() {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = conn.Do("PING")
+		_ = conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) Stop(ctx context.Context) error {
+	return c.topology.close()
+}
+
+func (c *redisCache) conn(ctx context.Context, key string) (redis.Conn, error) {
+	return c.topology.conn(ctx, key)
+}
+
+// splitHosts of a comma separated host list
+func splitHosts(list string) []string {
+	hosts := make([]string, 0)
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
 }
 
-func newRedisCache(host string, password string, defaultExpiration time.Duration) *redis.Pool {
+func newRedisPool(host string, password string) *redis.Pool {
+	return newRedisPoolWith(func() (redis.Conn, error) {
+		return dialRedis(host, password)
+	}, func(c redis.Conn, t time.Time) error {
+		_, err := c.Do("PING")
+		return err
+	})
+}
 
+func newRedisPoolWith(dial func() (redis.Conn, error), test func(redis.Conn, time.Time) error) *redis.Pool {
 	return &redis.Pool{
-		MaxIdle:     5,
-		MaxActive:   0,
-		IdleTimeout: time.Duration(240) * time.Second,
-		Dial: func() (redis.Conn, error) {
-			protocol := "tcp"
-			toc := time.Millisecond * time.Duration(10000)
-			tor := time.Millisecond * time.Duration(5000)
-			tow := time.Millisecond * time.Duration(5000)
-			c, err := redis.Dial(protocol, host,
-				redis.DialConnectTimeout(toc),
-				redis.DialReadTimeout(tor),
-				redis.DialWriteTimeout(tow))
-			if err != nil {
-				return nil, err
-			}
-			if len(password) > 0 {
-				if _, err = c.Do("AUTH", password); err != nil {
-					_ = c.Close()
-					return nil, err
-				}
-			} else {
-				// check with PING
-				if _, err = c.Do("PING"); err != nil {
-					_ = c.Close()
-					return nil, err
-				}
-			}
-			return c, err
-		},
-		// custom connection test method
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
-			return err
-		},
+		MaxIdle:      poolSize(),
+		MaxActive:    0,
+		IdleTimeout:  time.Duration(240) * time.Second,
+		Dial:         dial,
+		TestOnBorrow: test,
 	}
 }
 
+func dialRedis(host string, password string) (redis.Conn, error) {
+	protocol := "tcp"
+	toc := time.Millisecond * time.Duration(10000)
+	tor := time.Millisecond * time.Duration(5000)
+	tow := time.Millisecond * time.Duration(5000)
+	c, err := redis.Dial(protocol, host,
+		redis.DialConnectTimeout(toc),
+		redis.DialReadTimeout(tor),
+		redis.DialWriteTimeout(tow))
+	if err != nil {
+		return nil, err
+	}
+	if len(password) > 0 {
+		if _, err = c.Do("AUTH", password); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	} else {
+		// check with PING
+		if _, err = c.Do("PING"); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, err
+}
+
 func (c *redisCache) Set(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
+	return c.SetCtx(context.Background(), key, value, expires)
+}
+
+// SetCtx is Set within the deadline of ctx
+func (c *redisCache) SetCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	return c.invoke(conn.Do, key, value, expires)
+	return c.invoke(ctx, conn, key, value, expires)
 }
 
 func (c *redisCache) Add(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
+	return c.AddCtx(context.Background(), key, value, expires)
+}
+
+// AddCtx is Add within the deadline of ctx
+func (c *redisCache) AddCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
 
-	existed, err := exists(conn, key)
+	existed, err := exists(ctx, conn, key)
 	if err != nil {
 		return err
 	} else if existed {
 		return ErrNotStored
 	}
-	return c.invoke(conn.Do, key, value, expires)
+	return c.invoke(ctx, conn, key, value, expires)
 }
 
 func (c *redisCache) Replace(key string, value interface{}, expires time.Duration) error {
-	conn := c.pool.Get()
+	return c.ReplaceCtx(context.Background(), key, value, expires)
+}
+
+// ReplaceCtx is Replace within the deadline of ctx
+func (c *redisCache) ReplaceCtx(ctx context.Context, key string, value interface{}, expires time.Duration) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
 
-	existed, err := exists(conn, key)
+	existed, err := exists(ctx, conn, key)
 	if err != nil {
 		return err
 	} else if !existed {
 		return ErrNotStored
 	}
 
-	err = c.invoke(conn.Do, key, value, expires)
+	err = c.invoke(ctx, conn, key, value, expires)
 	if value == nil {
 		return ErrNotStored
 	}
@@ -124,11 +229,19 @@ func (c *redisCache) Replace(key string, value interface{}, expires time.Duratio
 }
 
 func (c *redisCache) Get(key string, ptrValue interface{}) error {
-	conn := c.pool.Get()
+	return c.GetCtx(context.Background(), key, ptrValue)
+}
+
+// GetCtx is Get within the deadline of ctx
+func (c *redisCache) GetCtx(ctx context.Context, key string, ptrValue interface{}) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	raw, err := conn.Do("GET", key)
+	raw, err := do(ctx, conn, "GET", key)
 	if err != nil {
 		return err
 	} else if raw == nil {
@@ -150,12 +263,48 @@ func generalizeStringSlice(strs []string) []interface{} {
 }
 
 func (c *redisCache) GetMulti(keys ...string) (Getter, error) {
-	conn := c.pool.Get()
+	return c.GetMultiCtx(context.Background(), keys...)
+}
+
+// GetMultiCtx reads the keys of each node, or of each slot in cluster mode,
+// with one MGET, the nodes are read concurrently within the deadline of ctx.
+func (c *redisCache) GetMultiCtx(ctx context.Context, keys ...string) (Getter, error) {
+	groups := c.topology.group(keys)
+	results := make([]map[string][]byte, len(groups))
+	errs := make([]error, len(groups))
+	var waitGroup sync.WaitGroup
+	for i, group := range groups {
+		waitGroup.Add(1)
+		go func(i int, group []string) {
+			defer waitGroup.Done()
+			results[i], errs[i] = c.mget(ctx, group)
+		}(i, group)
+	}
+	waitGroup.Wait()
+
+	m := make(map[string][]byte)
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for key, item := range result {
+			m[key] = item
+		}
+	}
+	return redisItemMapGetter(m), nil
+}
+
+// mget the keys served by one node
+func (c *redisCache) mget(ctx context.Context, keys []string) (map[string][]byte, error) {
+	conn, err := c.conn(ctx, keys[0])
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
 
-	items, err := redis.Values(conn.Do("MGET", generalizeStringSlice(keys)...))
+	items, err := redis.Values(do(ctx, conn, "MGET", generalizeStringSlice(keys)...))
 	if err != nil {
 		return nil, err
 	} else if items == nil {
@@ -172,19 +321,27 @@ func (c *redisCache) GetMulti(keys ...string) (Getter, error) {
 			}
 		}
 	}
-	return redisItemMapGetter(m), nil
+	return m, nil
 }
 
-func exists(conn redis.Conn, key string) (bool, error) {
-	return redis.Bool(conn.Do("EXISTS", key))
+func exists(ctx context.Context, conn redis.Conn, key string) (bool, error) {
+	return redis.Bool(do(ctx, conn, "EXISTS", key))
 }
 
 func (c *redisCache) Delete(key string) error {
-	conn := c.pool.Get()
+	return c.DeleteCtx(context.Background(), key)
+}
+
+// DeleteCtx is Delete within the deadline of ctx
+func (c *redisCache) DeleteCtx(ctx context.Context, key string) error {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	existed, err := redis.Bool(conn.Do("DEL", key))
+	existed, err := redis.Bool(do(ctx, conn, "DEL", key))
 	if err == nil && !existed {
 		err = ErrCacheMiss
 	}
@@ -192,7 +349,15 @@ func (c *redisCache) Delete(key string) error {
 }
 
 func (c *redisCache) Increment(key string, delta uint64) (uint64, error) {
-	conn := c.pool.Get()
+	return c.IncrementCtx(context.Background(), key, delta)
+}
+
+// IncrementCtx is Increment within the deadline of ctx
+func (c *redisCache) IncrementCtx(ctx context.Context, key string, delta uint64) (uint64, error) {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return 0, err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
@@ -200,7 +365,7 @@ func (c *redisCache) Increment(key string, delta uint64) (uint64, error) {
 	// redis will auto create the key, and we don't want that. Since we need to do increment
 	// ourselves instead of natively via INCRBY (redis doesn't support wrapping), we get the value
 	// and do the exists check this way to minimize calls to Redis
-	val, err := conn.Do("GET", key)
+	val, err := do(ctx, conn, "GET", key)
 	if err != nil {
 		return 0, err
 	} else if val == nil {
@@ -211,7 +376,7 @@ func (c *redisCache) Increment(key string, delta uint64) (uint64, error) {
 		return 0, err
 	}
 	sum := currentVal + int64(delta)
-	_, err = conn.Do("SET", key, sum)
+	_, err = do(ctx, conn, "SET", key, sum)
 	if err != nil {
 		return 0, err
 	}
@@ -219,13 +384,21 @@ func (c *redisCache) Increment(key string, delta uint64) (uint64, error) {
 }
 
 func (c *redisCache) Decrement(key string, delta uint64) (newValue uint64, err error) {
-	conn := c.pool.Get()
+	return c.DecrementCtx(context.Background(), key, delta)
+}
+
+// DecrementCtx is Decrement within the deadline of ctx
+func (c *redisCache) DecrementCtx(ctx context.Context, key string, delta uint64) (newValue uint64, err error) {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return 0, err
+	}
 	defer func() {
 		_ = conn.Close()
 	}()
 	// Check for existence *before* increment as per the cache contract.
 	// redis will auto create the key, and we don't want that, hence the exists call
-	existed, err := exists(conn, key)
+	existed, err := exists(ctx, conn, key)
 	if err != nil {
 		return 0, err
 	} else if !existed {
@@ -234,51 +407,171 @@ func (c *redisCache) Decrement(key string, delta uint64) (newValue uint64, err e
 	// Decrement contract says you can only go to 0
 	// so we go fetch the value and if the delta is greater than the amount,
 	// 0 out the value
-	currentVal, err := redis.Int64(conn.Do("GET", key))
+	currentVal, err := redis.Int64(do(ctx, conn, "GET", key))
 	if err != nil {
 		return 0, err
 	}
 	if delta > uint64(currentVal) {
 		var tempint int64
-		tempint, err = redis.Int64(conn.Do("DECRBY", key, currentVal))
+		tempint, err = redis.Int64(do(ctx, conn, "DECRBY", key, currentVal))
 		return uint64(tempint), err
 	}
-	tempint, err := redis.Int64(conn.Do("DECRBY", key, delta))
+	tempint, err := redis.Int64(do(ctx, conn, "DECRBY", key, delta))
 	return uint64(tempint), err
 }
 
 func (c *redisCache) Flush() error {
-	conn := c.pool.Get()
-	defer func() {
+	return c.FlushCtx(context.Background())
+}
+
+// FlushCtx flushes every node holding keys within the deadline of ctx
+func (c *redisCache) FlushCtx(ctx context.Context) error {
+	for _, pool := range c.topology.masters() {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = do(ctx, conn, "FLUSHALL")
 		_ = conn.Close()
-	}()
-	_, err := conn.Do("FLUSHALL")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisCache) invoke(ctx context.Context, conn redis.Conn, key string, value interface{}, expires time.Duration) error {
+	expires = c.expiration(expires)
+	b, err := serialize(c.codec, value)
+	if err != nil {
+		return err
+	}
+	if expires > 0 {
+		_, err = do(ctx, conn, "SETEX", key, int32(expires/time.Second), b)
+		return err
+	}
+	_, err = do(ctx, conn, "SET", key, b)
 	return err
 }
 
-func (c *redisCache) invoke(f func(string, ...interface{}) (interface{}, error),
-	key string, value interface{}, expires time.Duration) error {
+// do command on conn, the reply is awaited until the deadline of ctx at most
+func do(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return conn.Do(command, args...)
+	}
+	reply, err := redis.DoWithTimeout(conn, time.Until(deadline), command, args...)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
 
+// expiration of the values set with expires, 0 never expires
+func (c *redisCache) expiration(expires time.Duration) time.Duration {
 	switch expires {
 	case DefaultExpiryTime:
-		expires = c.defaultExpiration
+		return c.defaultExpiration
 	case ForEverNeverExpiry:
-		expires = time.Duration(0)
+		return time.Duration(0)
 	}
+	return expires
+}
+
+// tagScript adds a key to the set of a tag, the set lives as long as the
+// longest lived of its keys.
+var tagScript = redis.NewScript(1, `
+local ttl = redis.call('TTL', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local expires = tonumber(ARGV[2])
+if expires <= 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif ttl == -2 or (ttl >= 0 and ttl < expires) then
+	redis.call('EXPIRE', KEYS[1], expires)
+end
+return 1
+`)
+
+// SetWithTags sets key then adds it to the set of each tag
+func (c *redisCache) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	if err := c.Set(key, value, expires); err != nil {
+		return err
+	}
+	seconds := int64(c.expiration(expires) / time.Second)
+	for _, tag := range tags {
+		if err := c.tag(tagKey(tag), key, seconds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	b, err := serialize(value)
+func (c *redisCache) tag(set string, key string, seconds int64) error {
+	conn, err := c.conn(context.Background(), set)
 	if err != nil {
 		return err
 	}
-	conn := c.pool.Get()
 	defer func() {
 		_ = conn.Close()
 	}()
-	if expires > 0 {
-		_, err = f("SETEX", key, int32(expires/time.Second), b)
+	_, err = tagScript.Do(conn, set, key, seconds)
+	return err
+}
+
+// InvalidateTag deletes the keys in the set of each tag, and the sets
+func (c *redisCache) InvalidateTag(tags ...string) error {
+	_, err := c.invalidateTags(tags)
+	return err
+}
+
+// invalidateTags returns the keys deleted
+func (c *redisCache) invalidateTags(tags []string) ([]string, error) {
+	keys := make([]string, 0)
+	for _, tag := range tags {
+		members, err := c.untag(tagKey(tag))
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, members...)
+	}
+	for _, group := range c.topology.group(keys) {
+		if err := c.del(group); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// untag returns the members of the set and deletes it
+func (c *redisCache) untag(set string) ([]string, error) {
+	conn, err := c.conn(context.Background(), set)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	members, err := redis.Strings(conn.Do("SMEMBERS", set))
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Do("DEL", set)
+	return members, err
+}
+
+// del the keys served by one node
+func (c *redisCache) del(keys []string) error {
+	conn, err := c.conn(context.Background(), keys[0])
+	if err != nil {
 		return err
 	}
-	_, err = f("SET", key, b)
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("DEL", generalizeStringSlice(keys)...)
 	return err
 }
 
@@ -289,3 +582,77 @@ func (g redisItemMapGetter) Get(key string, ptrValue interface{}) error {
 	}
 	return deserialize(item, ptrValue)
 }
+
+// publish message on channel, the node of the channel is the node a key
+// named channel is on.
+func (c *redisCache) publish(channel string, message []byte) error {
+	conn, err := c.conn(context.Background(), channel)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_, err = conn.Do("PUBLISH", channel, message)
+	return err
+}
+
+// subscribe to channel until ctx is done, resubscribing when the connection
+// drops. subscribed is called every time the subscription is established.
+func (c *redisCache) subscribe(ctx context.Context, channel string, subscribed func(), onMessage func([]byte)) error {
+	backoff := time.Second
+	for {
+		err := c.receive(ctx, channel, subscribed, onMessage)
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.WithFields(log.Fields{
+			"Channel": channel,
+			"Error":   err,
+		}).Error("Redis subscription dropped, resubscribing")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (c *redisCache) receive(ctx context.Context, channel string, subscribed func(), onMessage func([]byte)) error {
+	conn, err := c.topology.conn(ctx, channel)
+	if err != nil {
+		return err
+	}
+	pubsub := redis.PubSubConn{Conn: conn}
+	defer func() {
+		_ = pubsub.Close()
+	}()
+	if err := pubsub.Subscribe(channel); err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = pubsub.Unsubscribe()
+	}()
+
+	for {
+		switch message := pubsub.ReceiveWithTimeout(0).(type) {
+		case redis.Message:
+			onMessage(message.Data)
+		case redis.Subscription:
+			switch message.Kind {
+			case "subscribe":
+				subscribed()
+			case "unsubscribe":
+				if message.Count == 0 {
+					return ctx.Err()
+				}
+			}
+		case error:
+			return message
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	clusterSlots     = 16384
+	clusterRedirects = 5
+)
+
+var ErrClusterSlots = errors.New("redis cluster slots unknown")
+
+// clusterRedis routes the keys to the master of their slot, the slots are
+// loaded from the seeds and reloaded when a node redirects a key.
+type clusterRedis struct {
+	mu       sync.RWMutex
+	seeds    []string
+	password string
+	slots    [clusterSlots]string
+	loaded   bool
+	pools    map[string]*redis.Pool
+	loading  sync.Mutex
+}
+
+// clusterConn follows the MOVED and ASK redirections of the cluster
+type clusterConn struct {
+	redis.Conn
+	cluster *clusterRedis
+	ctx     context.Context
+}
+
+func newClusterRedis(seeds []string, password string) *clusterRedis {
+	return &clusterRedis{
+		seeds:    seeds,
+		password: password,
+		pools:    make(map[string]*redis.Pool),
+	}
+}
+
+func (c *clusterRedis) conn(ctx context.Context, key string) (redis.Conn, error) {
+	address, err := c.address(keySlot(key))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.pool(address).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterConn{Conn: conn, cluster: c, ctx: ctx}, nil
+}
+
+// group the keys by slot, a multi key command fails across slots even when
+// they are on the same node.
+func (c *clusterRedis) group(keys []string) [][]string {
+	indexes := make(map[uint16]int)
+	groups := make([][]string, 0)
+	for _, key := range keys {
+		slot := keySlot(key)
+		i, ok := indexes[slot]
+		if !ok {
+			i = len(groups)
+			indexes[slot] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+func (c *clusterRedis) masters() []*redis.Pool {
+	if _, err := c.address(0); err != nil {
+		log.WithField("Error", err).Error("Loading redis cluster slots failed")
+	}
+	c.mu.RLock()
+	addresses := make(map[string]bool)
+	for _, address := range c.slots {
+		if address != "" {
+			addresses[address] = true
+		}
+	}
+	c.mu.RUnlock()
+	pools := make([]*redis.Pool, 0, len(addresses))
+	for address := range addresses {
+		pools = append(pools, c.pool(address))
+	}
+	return pools
+}
+
+func (c *clusterRedis) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for _, pool := range c.pools {
+		if closeErr := pool.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// address of the master of slot, the slots are loaded the first time
+func (c *clusterRedis) address(slot uint16) (string, error) {
+	c.mu.RLock()
+	address, loaded := c.slots[slot], c.loaded
+	c.mu.RUnlock()
+	if !loaded {
+		if err := c.load(); err != nil {
+			return "", err
+		}
+		c.mu.RLock()
+		address = c.slots[slot]
+		c.mu.RUnlock()
+	}
+	if address == "" {
+		return "", fmt.Errorf("%w: slot %d is not served", ErrClusterSlots, slot)
+	}
+	return address, nil
+}
+
+func (c *clusterRedis) pool(address string) *redis.Pool {
+	c.mu.RLock()
+	pool, ok := c.pools[address]
+	c.mu.RUnlock()
+	if ok {
+		return pool
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool, ok = c.pools[address]; !ok {
+		pool = newRedisPool(address, c.password)
+		c.pools[address] = pool
+	}
+	return pool
+}
+
+// moved records the new master of slot and reloads the slots in the
+// background, the other slots moved with it are likely to be asked next.
+func (c *clusterRedis) moved(slot uint16, address string) {
+	c.mu.Lock()
+	c.slots[slot] = address
+	c.mu.Unlock()
+	go func() {
+		if err := c.load(); err != nil {
+			log.WithField("Error", err).Error("Reloading redis cluster slots failed")
+		}
+	}()
+}
+
+// load the slots from the first node answering, the known masters are asked
+// before the seeds.
+func (c *clusterRedis) load() error {
+	c.loading.Lock()
+	defer c.loading.Unlock()
+	c.mu.RLock()
+	nodes := make([]string, 0, len(c.pools)+len(c.seeds))
+	for address := range c.pools {
+		nodes = append(nodes, address)
+	}
+	c.mu.RUnlock()
+	nodes = append(nodes, c.seeds...)
+
+	var lastErr error
+	for _, node := range nodes {
+		slots, err := c.askSlots(node)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		c.mu.Lock()
+		c.slots = slots
+		c.loaded = true
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("%w: %v", ErrClusterSlots, lastErr)
+}
+
+func (c *clusterRedis) askSlots(node string) ([clusterSlots]string, error) {
+	var slots [clusterSlots]string
+	conn := c.pool(node).Get()
+	defer func() {
+		_ = conn.Close()
+	}()
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return slots, err
+	}
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return slots, fmt.Errorf("%w: invalid slot range %v", ErrClusterSlots, r)
+		}
+		start, _ := redis.Int(fields[0], nil)
+		end, _ := redis.Int(fields[1], nil)
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return slots, fmt.Errorf("%w: invalid slot master %v", ErrClusterSlots, fields[2])
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if host == "" {
+			// the node answering does not know its own address
+			host, _, _ = net.SplitHostPort(node)
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end && slot < clusterSlots; slot++ {
+			slots[slot] = address
+		}
+	}
+	return slots, nil
+}
+
+// Do the command on the node of its key, following the redirections
+func (c *clusterConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.redirect(func(conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+		return conn.Do(command, args...)
+	}, command, args...)
+}
+
+// DoWithTimeout is Do awaiting each reply for timeout at most
+func (c *clusterConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.redirect(func(conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+		return redis.DoWithTimeout(conn, timeout, command, args...)
+	}, command, args...)
+}
+
+// ReceiveWithTimeout a pushed message, of a subscription for one
+func (c *clusterConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+// redirect the command until it reaches the node of its key
+func (c *clusterConn) redirect(do func(redis.Conn, string, ...interface{}) (interface{}, error),
+	command string, args ...interface{}) (interface{}, error) {
+
+	reply, err := do(c.Conn, command, args...)
+	for i := 0; i < clusterRedirects; i++ {
+		redirect, slot, address, ok := parseRedirect(err)
+		if !ok {
+			return reply, err
+		}
+		conn, dialErr := c.cluster.pool(address).GetContext(c.ctx)
+		if dialErr != nil {
+			return nil, dialErr
+		}
+		if redirect == "MOVED" {
+			// the next commands of this conn are for the new master too
+			c.cluster.moved(slot, address)
+			_ = c.Conn.Close()
+			c.Conn = conn
+			reply, err = do(c.Conn, command, args...)
+			continue
+		}
+		// ASK redirects this command only, while the slot is migrating
+		if _, err = do(conn, "ASKING"); err == nil {
+			reply, err = do(conn, command, args...)
+		}
+		_ = conn.Close()
+	}
+	return reply, err
+}
+
+// parseRedirect of an error like MOVED 3999 127.0.0.1:6381
+func parseRedirect(err error) (string, uint16, string, bool) {
+	redisErr, ok := err.(redis.Error)
+	if !ok {
+		return "", 0, "", false
+	}
+	fields := strings.Fields(string(redisErr))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, parseErr := strconv.ParseUint(fields[1], 10, 16)
+	if parseErr != nil {
+		return "", 0, "", false
+	}
+	return fields[0], uint16(slot), fields[2], true
+}
+
+// keySlot of key, the CRC16 of its hash tag modulo the slots
+func keySlot(key string) uint16 {
+	return crc16([]byte(hashTag(key))) % clusterSlots
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) used by redis cluster
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
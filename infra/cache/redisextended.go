@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func (c *redisCache) HSet(ctx context.Context, key string, fields map[string]string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, 1+2*len(fields))
+	args = append(args, key)
+	for field, value := range fields {
+		args = append(args, field, value)
+	}
+	return redis.Int(c.command(ctx, key, "HSET", args...))
+}
+
+func (c *redisCache) HGet(ctx context.Context, key string, field string) (string, error) {
+	return redis.String(c.commandOrMiss(ctx, key, "HGET", key, field))
+}
+
+func (c *redisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return redis.StringMap(c.command(ctx, key, "HGETALL", key))
+}
+
+func (c *redisCache) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	if len(fields) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.command(ctx, key, "HDEL", keyArgs(key, fields)...))
+}
+
+func (c *redisCache) HIncrBy(ctx context.Context, key string, field string, n int64) (int64, error) {
+	return redis.Int64(c.command(ctx, key, "HINCRBY", key, field, n))
+}
+
+func (c *redisCache) ZAdd(ctx context.Context, key string, members ...ZMember) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, 1+2*len(members))
+	args = append(args, key)
+	for _, member := range members {
+		args = append(args, member.Score, member.Member)
+	}
+	return redis.Int(c.command(ctx, key, "ZADD", args...))
+}
+
+func (c *redisCache) ZIncrBy(ctx context.Context, key string, member string, n float64) (float64, error) {
+	return redis.Float64(c.command(ctx, key, "ZINCRBY", key, n, member))
+}
+
+func (c *redisCache) ZScore(ctx context.Context, key string, member string) (float64, error) {
+	return redis.Float64(c.commandOrMiss(ctx, key, "ZSCORE", key, member))
+}
+
+func (c *redisCache) ZRevRank(ctx context.Context, key string, member string) (int, error) {
+	return redis.Int(c.commandOrMiss(ctx, key, "ZREVRANK", key, member))
+}
+
+func (c *redisCache) ZRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	return zMembers(c.command(ctx, key, "ZRANGE", key, start, stop, "WITHSCORES"))
+}
+
+func (c *redisCache) ZRevRange(ctx context.Context, key string, start int, stop int) ([]ZMember, error) {
+	return zMembers(c.command(ctx, key, "ZREVRANGE", key, start, stop, "WITHSCORES"))
+}
+
+func (c *redisCache) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.command(ctx, key, "ZREM", keyArgs(key, members)...))
+}
+
+func (c *redisCache) ZCard(ctx context.Context, key string) (int, error) {
+	return redis.Int(c.command(ctx, key, "ZCARD", key))
+}
+
+func (c *redisCache) LPush(ctx context.Context, key string, values ...string) (int, error) {
+	if len(values) == 0 {
+		return c.LLen(ctx, key)
+	}
+	return redis.Int(c.command(ctx, key, "LPUSH", keyArgs(key, values)...))
+}
+
+func (c *redisCache) RPush(ctx context.Context, key string, values ...string) (int, error) {
+	if len(values) == 0 {
+		return c.LLen(ctx, key)
+	}
+	return redis.Int(c.command(ctx, key, "RPUSH", keyArgs(key, values)...))
+}
+
+func (c *redisCache) LPop(ctx context.Context, key string) (string, error) {
+	return redis.String(c.commandOrMiss(ctx, key, "LPOP", key))
+}
+
+func (c *redisCache) RPop(ctx context.Context, key string) (string, error) {
+	return redis.String(c.commandOrMiss(ctx, key, "RPOP", key))
+}
+
+func (c *redisCache) LRange(ctx context.Context, key string, start int, stop int) ([]string, error) {
+	return redis.Strings(c.command(ctx, key, "LRANGE", key, start, stop))
+}
+
+func (c *redisCache) LLen(ctx context.Context, key string) (int, error) {
+	return redis.Int(c.command(ctx, key, "LLEN", key))
+}
+
+func (c *redisCache) SAdd(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.command(ctx, key, "SADD", keyArgs(key, members)...))
+}
+
+func (c *redisCache) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.command(ctx, key, "SREM", keyArgs(key, members)...))
+}
+
+func (c *redisCache) SIsMember(ctx context.Context, key string, member string) (bool, error) {
+	return redis.Bool(c.command(ctx, key, "SISMEMBER", key, member))
+}
+
+func (c *redisCache) SMembers(ctx context.Context, key string) ([]string, error) {
+	return redis.Strings(c.command(ctx, key, "SMEMBERS", key))
+}
+
+func (c *redisCache) SCard(ctx context.Context, key string) (int, error) {
+	return redis.Int(c.command(ctx, key, "SCARD", key))
+}
+
+func (c *redisCache) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return redis.Bool(c.command(ctx, key, "PEXPIRE", key, int64(ttl/time.Millisecond)))
+}
+
+func (c *redisCache) Pipeline(ctx context.Context, key string, commands ...Command) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	for _, command := range commands {
+		if err := conn.Send(command.Name, command.Args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	var firstErr error
+	for i := range commands {
+		reply, err := receiveReply(ctx, conn)
+		if _, ok := err.(redis.Error); !ok && err != nil {
+			// the connection failed, the next replies are lost
+			return nil, redisError(err)
+		}
+		if err != nil {
+			reply = redisError(err)
+			if firstErr == nil {
+				firstErr = reply.(error)
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+func (c *redisCache) Eval(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	if len(keys) != script.keyCount {
+		return nil, fmt.Errorf("%w: the script takes %d keys, got %d", ErrSyntax, script.keyCount, len(keys))
+	}
+	key := ""
+	if len(keys) > 0 {
+		key = keys[0]
+	}
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	reply, err := script.script.Do(&deadlineConn{Conn: conn, ctx: ctx}, append(generalizeStringSlice(keys), args...)...)
+	return reply, redisError(err)
+}
+
+// command on the node of key within the deadline of ctx
+func (c *redisCache) command(ctx context.Context, key string, command string, args ...interface{}) (interface{}, error) {
+	conn, err := c.conn(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	reply, err := do(ctx, conn, command, args...)
+	return reply, redisError(err)
+}
+
+// commandOrMiss is command returning ErrCacheMiss for a nil reply
+func (c *redisCache) commandOrMiss(ctx context.Context, key string, command string, args ...interface{}) (interface{}, error) {
+	reply, err := c.command(ctx, key, command, args...)
+	if err == nil && reply == nil {
+		return nil, ErrCacheMiss
+	}
+	return reply, err
+}
+
+// receiveReply of a pipeline until the deadline of ctx at most
+func receiveReply(ctx context.Context, conn redis.Conn) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return conn.Receive()
+	}
+	reply, err := redis.ReceiveWithTimeout(conn, time.Until(deadline))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+// deadlineConn does the commands of a script within the deadline of ctx
+type deadlineConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+func (c *deadlineConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return do(c.ctx, c.Conn, command, args...)
+}
+
+// redisError wraps a WRONGTYPE error in ErrWrongType
+func redisError(err error) error {
+	if redisErr, ok := err.(redis.Error); ok && strings.HasPrefix(string(redisErr), "WRONGTYPE") {
+		return fmt.Errorf("%w: %s", ErrWrongType, redisErr)
+	}
+	return err
+}
+
+// zMembers of a WITHSCORES reply
+func zMembers(reply interface{}, err error) ([]ZMember, error) {
+	values, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]ZMember, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		score, err := redis.Float64([]byte(values[i+1]), nil)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: values[i], Score: score})
+	}
+	return members, nil
+}
+
+// keyArgs of a command on key taking values
+func keyArgs(key string, values []string) []interface{} {
+	args := make([]interface{}, 0, 1+len(values))
+	args = append(args, key)
+	for _, value := range values {
+		args = append(args, value)
+	}
+	return args
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// sentinelRedis connects to the master the sentinels elect, a connection
+// borrowed from the pool is checked to still be on the master so that a
+// failover is followed by dialing the new master.
+type sentinelRedis struct {
+	mu        sync.Mutex
+	sentinels []string
+	name      string
+	password  string
+	master    string
+	pool      *redis.Pool
+}
+
+func newSentinelRedis(sentinels []string, name string, password string) *sentinelRedis {
+	c := &sentinelRedis{
+		sentinels: sentinels,
+		name:      name,
+		password:  password,
+	}
+	c.pool = newRedisPoolWith(c.dial, func(conn redis.Conn, t time.Time) error {
+		return isMaster(conn)
+	})
+	return c
+}
+
+func (c *sentinelRedis) conn(ctx context.Context, key string) (redis.Conn, error) {
+	return c.pool.GetContext(ctx)
+}
+
+func (c *sentinelRedis) group(keys []string) [][]string {
+	if len(keys) == 0 {
+		return nil
+	}
+	return [][]string{keys}
+}
+
+func (c *sentinelRedis) masters() []*redis.Pool {
+	return []*redis.Pool{c.pool}
+}
+
+func (c *sentinelRedis) close() error {
+	return c.pool.Close()
+}
+
+// dial the master the sentinels know of
+func (c *sentinelRedis) dial() (redis.Conn, error) {
+	master, err := c.discover()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialRedis(master, c.password)
+	if err != nil {
+		return nil, err
+	}
+	if err := isMaster(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// discover the address of the master from the first sentinel answering,
+// which is moved to the front of the sentinels asked next time.
+func (c *sentinelRedis) discover() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var lastErr error
+	for i, sentinel := range c.sentinels {
+		master, err := c.askSentinel(sentinel)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if i > 0 {
+			c.sentinels[0], c.sentinels[i] = c.sentinels[i], c.sentinels[0]
+		}
+		if master != c.master {
+			log.WithFields(log.Fields{
+				"Name":   c.name,
+				"Master": master,
+			}).Info("Redis master discovered")
+			c.master = master
+		}
+		return master, nil
+	}
+	return "", fmt.Errorf("%w: %s: %v", ErrRedisMasterNotFound, c.name, lastErr)
+}
+
+func (c *sentinelRedis) askSentinel(sentinel string) (string, error) {
+	conn, err := redis.Dial("tcp", sentinel,
+		redis.DialConnectTimeout(time.Second),
+		redis.DialReadTimeout(time.Second),
+		redis.DialWriteTimeout(time.Second))
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	address, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", c.name))
+	if err == redis.ErrNil {
+		return "", ErrRedisMasterNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(address) != 2 {
+		return "", fmt.Errorf("%w: invalid address %v", ErrRedisMasterNotFound, address)
+	}
+	return net.JoinHostPort(address[0], address[1]), nil
+}
+
+// isMaster fails when conn is no longer on a master, after a failover
+func isMaster(conn redis.Conn) error {
+	role, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(role) == 0 {
+		return fmt.Errorf("%w: empty role", ErrRedisMasterNotFound)
+	}
+	if name, _ := redis.String(role[0], nil); name != "master" {
+		return fmt.Errorf("%w: role is %s", ErrRedisMasterNotFound, name)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// replicas of each host on the hash ring, spreading the keys evenly
+const ringReplicas = 160
+
+// shardedRedis spreads the keys over the hosts by consistent hashing, adding
+// or removing a host moves only the keys of its share of the ring.
+type shardedRedis struct {
+	ring  []ringPoint
+	pools map[string]*redis.Pool
+	hosts []string
+}
+
+type ringPoint struct {
+	hash uint32
+	host string
+}
+
+func newShardedRedis(hosts []string, password string) *shardedRedis {
+	c := &shardedRedis{
+		ring:  make([]ringPoint, 0, len(hosts)*ringReplicas),
+		pools: make(map[string]*redis.Pool),
+		hosts: hosts,
+	}
+	for _, host := range hosts {
+		c.pools[host] = newRedisPool(host, password)
+		for i := 0; i < ringReplicas; i++ {
+			c.ring = append(c.ring, ringPoint{
+				hash: ringHash(host + "-" + strconv.Itoa(i)),
+				host: host,
+			})
+		}
+	}
+	sort.Slice(c.ring, func(i, j int) bool {
+		return c.ring[i].hash < c.ring[j].hash
+	})
+	return c
+}
+
+func (c *shardedRedis) conn(ctx context.Context, key string) (redis.Conn, error) {
+	return c.pools[c.host(key)].GetContext(ctx)
+}
+
+func (c *shardedRedis) group(keys []string) [][]string {
+	indexes := make(map[string]int)
+	groups := make([][]string, 0)
+	for _, key := range keys {
+		host := c.host(key)
+		i, ok := indexes[host]
+		if !ok {
+			i = len(groups)
+			indexes[host] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+func (c *shardedRedis) masters() []*redis.Pool {
+	pools := make([]*redis.Pool, 0, len(c.hosts))
+	for _, host := range c.hosts {
+		pools = append(pools, c.pools[host])
+	}
+	return pools
+}
+
+func (c *shardedRedis) close() error {
+	var err error
+	for _, pool := range c.pools {
+		if closeErr := pool.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// host of the first point of the ring at or after the hash of key
+func (c *shardedRedis) host(key string) string {
+	hash := ringHash(hashTag(key))
+	i := sort.Search(len(c.ring), func(i int) bool {
+		return c.ring[i].hash >= hash
+	})
+	if i == len(c.ring) {
+		i = 0
+	}
+	return c.ring[i].host
+}
+
+// ringHash spreads the keys evenly over the ring, unlike crc32 on short keys
+func ringHash(key string) uint32 {
+	sum := md5.Sum([]byte(key))
+	return binary.LittleEndian.Uint32(sum[:4])
+}
+
+// hashTag is the part of key between the first { and the next }, the keys
+// sharing a non empty tag are on the same node.
+func hashTag(key string) string {
+	for i := 0; i < len(key); i++ {
+		if key[i] == '{' {
+			for j := i + 1; j < len(key); j++ {
+				if key[j] == '}' {
+					if j > i+1 {
+						return key[i+1 : j]
+					}
+					return key
+				}
+			}
+			return key
+		}
+	}
+	return key
+}
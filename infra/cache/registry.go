@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-microservice/infra/server"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	backends = &caches{
+		factories: make(map[string]*factory),
+		swapped:   make(chan struct{}),
+	}
+	ErrNoCache      = errors.New("cache not configured")
+	ErrUnknownCache = errors.New("unknown cache backend")
+)
+
+// Factory creates the backend named in cache.local or cache.remote
+type Factory func() (Cache, error)
+
+// caches holds the local and remote backends selected by cache.local and
+// cache.remote, the remote one is swapped when its configuration changes.
+type caches struct {
+	mu           sync.RWMutex
+	factories    map[string]*factory
+	local        Cache
+	remote       Cache
+	remoteName   string
+	remoteConfig string
+	swapped      chan struct{}
+}
+
+// reconfigurable backends apply their new configuration in place
+type reconfigurable interface {
+	OnConfig()
+}
+
+type factory struct {
+	create Factory
+	keys   []string
+}
+
+func init() {
+	viper.SetDefault("cache.local", "memory")
+	viper.SetDefault("cache.remote", "redis")
+	viper.SetDefault("cache.swapgrace", "30s")
+	viper.SetDefault("cache.ttl", "1h")
+	viper.SetDefault("cache.poolsize", 5)
+	server.RegisterService(backends, server.Low)
+}
+
+// RegisterBackend makes a backend available as cache.local or cache.remote
+// name, the remote backend is created again when one of the configuration
+// keys it is created from changes.
+func RegisterBackend(name string, create Factory, keys ...string) {
+	backends.mu.Lock()
+	defer backends.mu.Unlock()
+	backends.factories[name] = &factory{create: create, keys: keys}
+}
+
+func (c *caches) Init() error {
+	local, err := c.create(viper.GetString("cache.local"))
+	if err != nil {
+		return err
+	}
+	if local == nil {
+		return fmt.Errorf("%w: a local cache is required", ErrNoCache)
+	}
+	name := viper.GetString("cache.remote")
+	remote, err := c.create(name)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.local = local
+	c.remote = remote
+	c.remoteName = name
+	c.remoteConfig = c.config(name)
+	log.WithFields(log.Fields{
+		"Local":  viper.GetString("cache.local"),
+		"Remote": name,
+	}).Info("Caches initialised")
+	return nil
+}
+
+// OnConfig reconfigures the local backend and swaps the remote one when
+// cache.remote or its configuration changes, the current one is kept when the
+// new one fails to be created. The previous one is stopped after
+// cache.swapgrace, the callers which got it before the swap finish with it.
+func (c *caches) OnConfig() {
+	if local, ok := localCache().(reconfigurable); ok {
+		local.OnConfig()
+	}
+
+	name := viper.GetString("cache.remote")
+	c.mu.RLock()
+	config := c.config(name)
+	unchanged := name == c.remoteName && config == c.remoteConfig
+	c.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	remote, err := c.create(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Remote": name,
+			"Error":  err,
+		}).Error("Swapping remote cache failed, keeping the current one")
+		return
+	}
+	c.mu.Lock()
+	previous := c.remote
+	c.remote = remote
+	c.remoteName = name
+	c.remoteConfig = config
+	close(c.swapped)
+	c.swapped = make(chan struct{})
+	c.mu.Unlock()
+	log.WithField("Remote", name).Info("Remote cache swapped")
+
+	go func() {
+		time.Sleep(viper.GetDuration("cache.swapgrace"))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := stopBackend(ctx, previous); err != nil {
+			log.WithField("Error", err).Error("Stopping previous remote cache failed")
+		}
+	}()
+}
+
+func (c *caches) Stop(ctx context.Context) error {
+	c.mu.RLock()
+	local, remote := c.local, c.remote
+	c.mu.RUnlock()
+	if err := stopBackend(ctx, remote); err != nil {
+		return err
+	}
+	return stopBackend(ctx, local)
+}
+
+func (c *caches) CheckHealth(ctx context.Context) error {
+	c.mu.RLock()
+	remote := c.remote
+	c.mu.RUnlock()
+	if checker, ok := remote.(server.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return nil
+}
+
+// create the backend name, none is no backend
+func (c *caches) create(name string) (Cache, error) {
+	if name == "none" || name == "" {
+		return nil, nil
+	}
+	c.mu.RLock()
+	factory, ok := c.factories[name]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCache, name)
+	}
+	return factory.create()
+}
+
+// config the backend name is created from, called with mu held
+func (c *caches) config(name string) string {
+	values := []string{viper.GetString("cache.ttl"), viper.GetString("cache.poolsize"), viper.GetString("cache.codec")}
+	if factory, ok := c.factories[name]; ok {
+		for _, key := range factory.keys {
+			values = append(values, viper.GetString(key))
+		}
+	}
+	return strings.Join(values, "\x00")
+}
+
+// current remote backend, nil when cache.remote is none, and a channel closed
+// when it is swapped.
+func (c *caches) current() (Cache, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.remote, c.swapped
+}
+
+func localCache() Cache {
+	backends.mu.RLock()
+	defer backends.mu.RUnlock()
+	return backends.local
+}
+
+// remoteCache is nil when cache.remote is none
+func remoteCache() Cache {
+	backends.mu.RLock()
+	defer backends.mu.RUnlock()
+	return backends.remote
+}
+
+func stopBackend(ctx context.Context, c Cache) error {
+	if stopper, ok := c.(server.Stopper); ok {
+		return stopper.Stop(ctx)
+	}
+	return nil
+}
+
+// defaultTTL of the values set with DefaultExpiryTime
+func defaultTTL() time.Duration {
+	return viper.GetDuration("cache.ttl")
+}
+
+// poolSize of the idle connections kept per remote host
+func poolSize() int {
+	return viper.GetInt("cache.poolsize")
+}
+
+// noCache stands for a backend which is not configured
+type noCache struct{}
+
+func (noCache) Get(key string, ptrValue interface{}) error {
+	return ErrNoCache
+}
+
+func (noCache) GetMulti(keys ...string) (Getter, error) {
+	return nil, ErrNoCache
+}
+
+func (noCache) Set(key string, value interface{}, expires time.Duration) error {
+	return ErrNoCache
+}
+
+func (noCache) Add(key string, value interface{}, expires time.Duration) error {
+	return ErrNoCache
+}
+
+func (noCache) Replace(key string, value interface{}, expires time.Duration) error {
+	return ErrNoCache
+}
+
+func (noCache) Delete(key string) error {
+	return ErrNoCache
+}
+
+func (noCache) Increment(key string, n uint64) (uint64, error) {
+	return 0, ErrNoCache
+}
+
+func (noCache) Decrement(key string, n uint64) (uint64, error) {
+	return 0, ErrNoCache
+}
+
+func (noCache) Flush() error {
+	return ErrNoCache
+}
@@ -3,13 +3,18 @@ package cache
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"reflect"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
 )
 
-func serialize(value interface{}) ([]byte, error) {
+// serialize value with codec, or the codec it was given WithCodec
+func serialize(codec Codec, value interface{}) ([]byte, error) {
+	if v, ok := value.(*codecValue); ok {
+		codec, value = v.codec, v.value
+	}
 	if data, ok := value.([]byte); ok {
 		return data, nil
 	}
@@ -21,16 +26,16 @@ func serialize(value interface{}) ([]byte, error) {
 		return []byte(strconv.FormatUint(v.Uint(), 10)), nil
 	}
 
-	var b bytes.Buffer
-	encoder := gob.NewEncoder(&b)
-	if err := encoder.Encode(value); err != nil {
+	data, err := encode(codec, value)
+	if err != nil {
 		log.WithFields(log.Fields{
 			"value": value,
+			"codec": codec.Name(),
 			"error": err,
-		}).Error("Serialize: gob encoding failed")
+		}).Error("Serialize: encoding failed")
 		return nil, err
 	}
-	return b.Bytes(), nil
+	return data, nil
 }
 
 func deserialize(byt []byte, ptr interface{}) (err error) {
@@ -69,6 +74,16 @@ func deserialize(byt []byte, ptr interface{}) (err error) {
 		}
 	}
 
+	if isEnvelope(byt) {
+		if err = decode(byt, ptr); err != nil && !errors.Is(err, ErrSchemaVersion) {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Deserialize: decoding failed")
+		}
+		return
+	}
+
+	// stored before the envelopes
 	b := bytes.NewBuffer(byt)
 	decoder := gob.NewDecoder(b)
 	if err = decoder.Decode(ptr); err != nil {
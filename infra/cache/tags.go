@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var ErrTagsUnsupported = errors.New("tags not supported")
+
+// Tagger is implemented by the backends deleting keys by tag
+type Tagger interface {
+	// SetWithTags sets key like Set, invalidating one of tags deletes it
+	SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error
+	// InvalidateTag deletes the keys set with one of tags
+	InvalidateTag(tags ...string) error
+}
+
+// Namespace groups the keys of a domain under the application and its name,
+// so that services sharing a remote cache do not overwrite each other.
+type Namespace struct {
+	name string
+}
+
+// NewNamespace of the keys of name, the application is read when the keys are
+// built so a namespace may be declared before the configuration is loaded.
+func NewNamespace(name string) Namespace {
+	return Namespace{name: name}
+}
+
+// Key in the namespace of the parts joined by colons, like
+// microservice:users:42 for users.Key(42), also usable as a tag.
+func (ns Namespace) Key(parts ...interface{}) string {
+	key := make([]string, 0, len(parts)+2)
+	key = append(key, viper.GetString("application"), ns.name)
+	for _, part := range parts {
+		key = append(key, fmt.Sprint(part))
+	}
+	return strings.Join(key, ":")
+}
+
+// SetWithTags sets key like Set, InvalidateTag of one of tags deletes it.
+func SetWithTags(remote bool, key string, value interface{}, expires time.Duration, tags ...string) error {
+	c := getCache(remote)
+	tagger, ok := c.(Tagger)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTagsUnsupported, backendName(c))
+	}
+	err := tagger.SetWithTags(key, value, expires, tags...)
+	observe(c, "set", err)
+	return err
+}
+
+// InvalidateTag deletes the keys set with one of tags, the loads of the keys
+// in progress still store their value.
+func InvalidateTag(remote bool, tags ...string) error {
+	c := getCache(remote)
+	tagger, ok := c.(Tagger)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTagsUnsupported, backendName(c))
+	}
+	err := tagger.InvalidateTag(tags...)
+	observe(c, "invalidate_tag", err)
+	return err
+}
+
+// tagKey of the data the remote backends keep per tag
+func tagKey(tag string) string {
+	return viper.GetString("application") + ":tag:" + tag
+}
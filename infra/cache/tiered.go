@@ -0,0 +1,476 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-microservice/infra/server"
+	"reflect"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var nearCache *tieredCache
+
+// tieredCache reads through its own in-memory cache to the remote one and
+// writes through both, the writes are broadcast so the other instances evict
+// their local copy. Flushing its copies leaves the local cache alone.
+type tieredCache struct {
+	mu       sync.RWMutex
+	local    *inMemoryCache
+	localTTL time.Duration
+	channel  string
+	source   string
+	once     sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// invalidationBroker broadcasts the invalidations of the tiered cache
+type invalidationBroker interface {
+	publish(channel string, message []byte) error
+	subscribe(ctx context.Context, channel string, subscribed func(), onMessage func([]byte)) error
+}
+
+// tagInvalidator invalidates tags telling the keys deleted
+type tagInvalidator interface {
+	invalidateTags(tags []string) ([]string, error)
+}
+
+// invalidation is the message broadcast on a write, a flush evicts every key
+type invalidation struct {
+	Source string   `json:"source"`
+	Keys   []string `json:"keys,omitempty"`
+	Flush  bool     `json:"flush,omitempty"`
+}
+
+// tieredGetter reads the keys of GetMulti through the local cache
+type tieredGetter struct {
+	cache  *tieredCache
+	local  Cache
+	remote Cache
+	getter Getter
+}
+
+func init() {
+	viper.SetDefault("cache.near.maxentries", 10000)
+	viper.SetDefault("cache.near.maxbytes", 32<<20)
+	viper.SetDefault("cache.near.eviction", "lru")
+	nearCache = &tieredCache{
+		local:  newInMemoryCache(0),
+		source: newSource(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	server.RegisterService(nearCache, server.Low, "caches")
+}
+
+// Near returns the tiered cache, reading from its in-memory copies before the
+// remote cache, it is an in-memory cache alone without a remote cache.
+func Near() Cache {
+	return nearCache
+}
+
+func (c *tieredCache) Init() error {
+	viper.SetDefault("cache.near.localttl", "1m")
+	viper.SetDefault("cache.near.channel", viper.GetString("application")+":cache:invalidate")
+	if err := c.local.configure("cache.near"); err != nil {
+		return err
+	}
+	go c.local.janitor(time.Minute)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localTTL = viper.GetDuration("cache.near.localttl")
+	c.channel = viper.GetString("cache.near.channel")
+	return nil
+}
+
+func (c *tieredCache) OnConfig() {
+	if err := c.local.configure("cache.near"); err != nil {
+		log.WithField("Error", err).Error("Reconfiguring near cache failed, keeping the current eviction policy")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.localTTL = viper.GetDuration("cache.near.localttl")
+}
+
+// Run listens to the invalidations of the other instances until stopped, on
+// the remote cache in use.
+func (c *tieredCache) Run(ctx context.Context) error {
+	defer close(c.done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.mu.RLock()
+	channel := c.channel
+	c.mu.RUnlock()
+	resubscribed := false
+	subscribed := func() {
+		// invalidations were missed while unsubscribed
+		if resubscribed {
+			c.flushLocal()
+		}
+		resubscribed = true
+		log.WithField("Channel", channel).Info("Listening to cache invalidations")
+	}
+	for {
+		remote, swapped := backends.current()
+		if broker, ok := remote.(invalidationBroker); ok {
+			subscription, unsubscribe := context.WithCancel(ctx)
+			go func() {
+				select {
+				case <-swapped:
+					unsubscribe()
+				case <-subscription.Done():
+				}
+			}()
+			err := broker.subscribe(subscription, channel, subscribed, c.evict)
+			unsubscribe()
+			if err != nil {
+				return err
+			}
+		}
+		select {
+		case <-swapped:
+			// the local copies are of the previous remote cache
+			c.flushLocal()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (c *tieredCache) Stop(ctx context.Context) error {
+	c.once.Do(func() {
+		close(c.stop)
+		_ = c.local.Stop(ctx)
+	})
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *tieredCache) Get(key string, ptrValue interface{}) error {
+	local, remote := c.tiers()
+	err := local.Get(key, ptrValue)
+	observe(local, "get", err)
+	if err == nil || remote == nil || (err != ErrCacheMiss && err != ErrInvalidValue) {
+		return err
+	}
+	err = remote.Get(key, ptrValue)
+	observe(remote, "get", err)
+	if err != nil {
+		return err
+	}
+	c.keep(local, key, reflect.ValueOf(ptrValue).Elem().Interface(), DefaultExpiryTime)
+	return nil
+}
+
+func (c *tieredCache) GetMulti(keys ...string) (Getter, error) {
+	local, remote := c.tiers()
+	if remote == nil {
+		return local.GetMulti(keys...)
+	}
+	getter, err := remote.GetMulti(keys...)
+	observe(remote, "get_multi", err)
+	if err != nil {
+		return nil, err
+	}
+	return &tieredGetter{cache: c, local: local, remote: remote, getter: getter}, nil
+}
+
+func (c *tieredCache) Set(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, "set")
+}
+
+func (c *tieredCache) Add(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, "add")
+}
+
+func (c *tieredCache) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, "replace")
+}
+
+// SetWithTags sets key in both tiers, the local copy keeps its tags
+func (c *tieredCache) SetWithTags(key string, value interface{}, expires time.Duration, tags ...string) error {
+	local, remote := c.tiers()
+	if remote == nil {
+		return tag(local, key, value, expires, tags)
+	}
+	if err := tag(remote, key, value, expires, tags); err != nil {
+		if errors.Is(err, ErrTagsUnsupported) {
+			return err
+		}
+		c.forget(local, key)
+		return err
+	}
+	if err := tag(local, key, value, c.localExpiry(expires), tags); err != nil {
+		c.forget(local, key)
+	}
+	c.broadcast(remote, invalidation{Keys: []string{key}})
+	return nil
+}
+
+// InvalidateTag in both tiers, the local copies read from the remote cache
+// have no tags so the keys deleted remotely are evicted, or the whole local
+// cache when the remote cache does not tell them.
+func (c *tieredCache) InvalidateTag(tags ...string) error {
+	local, remote := c.tiers()
+	if remote == nil {
+		return untag(local, tags)
+	}
+	if invalidator, ok := remote.(tagInvalidator); ok {
+		keys, err := invalidator.invalidateTags(tags)
+		for _, key := range keys {
+			c.forget(local, key)
+		}
+		if err == nil {
+			err = untag(local, tags)
+		}
+		if len(keys) > 0 {
+			c.broadcast(remote, invalidation{Keys: keys})
+		}
+		return err
+	}
+	if err := untag(remote, tags); err != nil {
+		return err
+	}
+	c.flushLocal()
+	c.broadcast(remote, invalidation{Flush: true})
+	return nil
+}
+
+func (c *tieredCache) Delete(key string) error {
+	local, remote := c.tiers()
+	if remote == nil {
+		err := local.Delete(key)
+		observe(local, "delete", err)
+		return err
+	}
+	err := remote.Delete(key)
+	observe(remote, "delete", err)
+	c.forget(local, key)
+	c.broadcast(remote, invalidation{Keys: []string{key}})
+	return err
+}
+
+func (c *tieredCache) Increment(key string, n uint64) (uint64, error) {
+	local, remote := c.tiers()
+	if remote == nil {
+		newValue, err := local.Increment(key, n)
+		observe(local, "increment", err)
+		return newValue, err
+	}
+	newValue, err := remote.Increment(key, n)
+	observe(remote, "increment", err)
+	if err == nil {
+		c.forget(local, key)
+		c.broadcast(remote, invalidation{Keys: []string{key}})
+	}
+	return newValue, err
+}
+
+func (c *tieredCache) Decrement(key string, n uint64) (uint64, error) {
+	local, remote := c.tiers()
+	if remote == nil {
+		newValue, err := local.Decrement(key, n)
+		observe(local, "decrement", err)
+		return newValue, err
+	}
+	newValue, err := remote.Decrement(key, n)
+	observe(remote, "decrement", err)
+	if err == nil {
+		c.forget(local, key)
+		c.broadcast(remote, invalidation{Keys: []string{key}})
+	}
+	return newValue, err
+}
+
+func (c *tieredCache) Flush() error {
+	local, remote := c.tiers()
+	if remote != nil {
+		if err := remote.Flush(); err != nil {
+			observe(remote, "flush", err)
+			return err
+		}
+		c.broadcast(remote, invalidation{Flush: true})
+	}
+	err := local.Flush()
+	observe(local, "flush", err)
+	return err
+}
+
+func (g *tieredGetter) Get(key string, ptrValue interface{}) error {
+	err := g.local.Get(key, ptrValue)
+	observe(g.local, "get", err)
+	if err != ErrCacheMiss && err != ErrInvalidValue {
+		return err
+	}
+	err = g.getter.Get(key, ptrValue)
+	observe(g.remote, "get", err)
+	if err != nil {
+		return err
+	}
+	g.cache.keep(g.local, key, reflect.ValueOf(ptrValue).Elem().Interface(), DefaultExpiryTime)
+	return nil
+}
+
+// write the key to the remote cache then the local one, other instances evict
+// their copy.
+func (c *tieredCache) write(key string, value interface{}, expires time.Duration, operation string) error {
+	local, remote := c.tiers()
+	if remote == nil {
+		err := store(local, operation, key, value, expires)
+		observe(local, operation, err)
+		return err
+	}
+	err := store(remote, operation, key, value, expires)
+	observe(remote, operation, err)
+	if err != nil {
+		// the remote value of a failed Add or Replace is unknown here
+		if err == ErrNotStored {
+			c.forget(local, key)
+		}
+		return err
+	}
+	c.keep(local, key, value, expires)
+	c.broadcast(remote, invalidation{Keys: []string{key}})
+	return nil
+}
+
+func tag(cache Cache, key string, value interface{}, expires time.Duration, tags []string) error {
+	tagger, ok := cache.(Tagger)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTagsUnsupported, backendName(cache))
+	}
+	err := tagger.SetWithTags(key, value, expires, tags...)
+	observe(cache, "set", err)
+	return err
+}
+
+func untag(cache Cache, tags []string) error {
+	tagger, ok := cache.(Tagger)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTagsUnsupported, backendName(cache))
+	}
+	err := tagger.InvalidateTag(tags...)
+	observe(cache, "invalidate_tag", err)
+	return err
+}
+
+func store(cache Cache, operation string, key string, value interface{}, expires time.Duration) error {
+	switch operation {
+	case "add":
+		return cache.Add(key, value, expires)
+	case "replace":
+		return cache.Replace(key, value, expires)
+	}
+	return cache.Set(key, value, expires)
+}
+
+// keep a copy of the value in the local cache for at most cache.near.localttl,
+// or until it expires when sooner.
+func (c *tieredCache) keep(local Cache, key string, value interface{}, expires time.Duration) {
+	if err := local.Set(key, value, c.localExpiry(expires)); err != nil {
+		log.WithFields(log.Fields{
+			"Key":   key,
+			"Error": err,
+		}).Error("Storing near cache copy failed")
+	}
+}
+
+// localExpiry of a copy of a value set with expires
+func (c *tieredCache) localExpiry(expires time.Duration) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if expires > 0 && expires < c.localTTL {
+		return expires
+	}
+	return c.localTTL
+}
+
+// forget the local copy of key
+func (c *tieredCache) forget(local Cache, key string) {
+	if err := local.Delete(key); err != nil && err != ErrCacheMiss {
+		log.WithFields(log.Fields{
+			"Key":   key,
+			"Error": err,
+		}).Error("Evicting near cache copy failed")
+	}
+}
+
+// broadcast the invalidation to the other instances
+func (c *tieredCache) broadcast(remote Cache, message invalidation) {
+	broker, ok := remote.(invalidationBroker)
+	if !ok {
+		return
+	}
+	message.Source = c.source
+	data, err := json.Marshal(message)
+	if err == nil {
+		c.mu.RLock()
+		channel := c.channel
+		c.mu.RUnlock()
+		err = broker.publish(channel, data)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Keys":  message.Keys,
+			"Error": err,
+		}).Error("Broadcasting cache invalidation failed")
+	}
+}
+
+// evict the local copies invalidated by another instance
+func (c *tieredCache) evict(data []byte) {
+	message := invalidation{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.WithField("Error", err).Error("Invalid cache invalidation")
+		return
+	}
+	if message.Source == c.source {
+		return
+	}
+	if message.Flush {
+		c.flushLocal()
+		return
+	}
+	for _, key := range message.Keys {
+		c.forget(c.local, key)
+	}
+}
+
+// tiers in use, remote is nil without a remote cache
+func (c *tieredCache) tiers() (Cache, Cache) {
+	return c.local, remoteCache()
+}
+
+// flushLocal copies, the local cache is left alone
+func (c *tieredCache) flushLocal() {
+	if err := c.local.Flush(); err != nil {
+		log.WithField("Error", err).Error("Flushing near cache failed")
+	}
+}
+
+// newSource identifies the instance in its broadcasts
+func newSource() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,38 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearCacheCopiesApart(t *testing.T) {
+	local := useMemoryCache(t)
+	near := &tieredCache{local: newInMemoryCache(time.Hour), localTTL: time.Minute}
+
+	if err := local.Set("users:count", int64(3), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := near.Set("users:1", "ada", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := near.Get("users:1", &name); err != nil || name != "ada" {
+		t.Fatalf("near cache got %q, %v", name, err)
+	}
+	if err := local.Get("users:1", &name); err != ErrCacheMiss {
+		t.Fatalf("near cache wrote to the local cache: %v", err)
+	}
+
+	// a resubscribe or a remote swap flushes the copies only
+	near.flushLocal()
+	if err := near.Get("users:1", &name); err != ErrCacheMiss {
+		t.Fatalf("expected the copy flushed, got %v", err)
+	}
+	var count int64
+	if err := local.Get("users:count", &count); err != nil || count != 3 {
+		t.Fatalf("flushing the near cache flushed the local cache: %d, %v", count, err)
+	}
+	if name := backendName(near); name != "near" {
+		t.Fatalf("near cache reported as %s", name)
+	}
+}
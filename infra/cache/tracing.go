@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"go-microservice/infra/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func startSpan(ctx context.Context, remote bool, operation string, keys ...string) trace.Span {
+	_, span := tracing.Start(ctx, "cache."+operation,
+		attribute.String("cache.backend", backendName(getCache(remote))),
+		attribute.Array("cache.keys", keys),
+	)
+	return span
+}
+
+// endSpan records a miss as an attribute, it is not an error of the cache.
+func endSpan(span trace.Span, err error) {
+	switch err {
+	case ErrCacheMiss:
+		span.SetAttributes(attribute.Bool("cache.hit", false))
+		err = nil
+	case nil:
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+	}
+	tracing.End(span, err)
+}
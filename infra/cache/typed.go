@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Typed reads and writes values of T on the local or remote cache, so that a
+// value of another type is a compile error instead of a decode error.
+type Typed[T any] struct {
+	remote bool
+}
+
+// NewTyped cache of T values, on the remote cache when remote is set
+func NewTyped[T any](remote bool) Typed[T] {
+	return Typed[T]{remote: remote}
+}
+
+// Get the value of key, ErrCacheMiss when missing
+func (t Typed[T]) Get(ctx context.Context, key string) (T, error) {
+	var value T
+	err := GetCtx(ctx, t.remote, key, &value)
+	return value, err
+}
+
+// Set key to value for expires
+func (t Typed[T]) Set(ctx context.Context, key string, value T, expires time.Duration) error {
+	return SetCtx(ctx, t.remote, key, value, expires)
+}
+
+// GetMulti values of the keys, the missing keys are left out
+func (t Typed[T]) GetMulti(ctx context.Context, keys ...string) (map[string]T, error) {
+	getter, err := GetMultiCtx(ctx, t.remote, keys...)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]T, len(keys))
+	for _, key := range keys {
+		var value T
+		err := getter.Get(key, &value)
+		if err == ErrCacheMiss {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Delete key, ErrCacheMiss when missing
+func (t Typed[T]) Delete(ctx context.Context, key string) error {
+	return DeleteCtx(ctx, t.remote, key)
+}
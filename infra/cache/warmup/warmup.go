@@ -0,0 +1,257 @@
+package warmup
+
+import (
+	"context"
+	"go-microservice/infra/cache"
+	"go-microservice/infra/dbs/postgres"
+	"go-microservice/infra/server"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	instance *cacheWarmup
+	// waitMigrated is stubbed by the tests
+	waitMigrated = postgres.WaitMigrated
+)
+
+// Warmer loads the keys of a repository once postgres is migrated, so that
+// the first requests after a deploy hit the cache, and refreshes the hot ones
+// before they expire.
+type Warmer struct {
+	// Name of the warmer in the logs
+	Name string
+	// Remote cache of the keys, or the local one
+	Remote bool
+	// Pattern of the keys, like users.Key("*"), matched by path.Match. A key
+	// read by cache.GetOrLoadCtx is hot until the next refresh.
+	Pattern string
+	// Keys loaded on startup and refreshed even when not read, the Pattern
+	// when nil and it has no wildcard.
+	Keys func(ctx context.Context) ([]string, error)
+	// Load the value of key, cache.ErrNotFound when there is none
+	Load cache.Loader
+	// TTL of the values, cache.ForEverNeverExpiry for none
+	TTL time.Duration
+	// Refresh interval, 3/4 of the TTL when 0. The keys are only loaded on
+	// startup when both are 0.
+	Refresh time.Duration
+}
+
+// cacheWarmup runs the warmers registered by the repositories
+type cacheWarmup struct {
+	mu      sync.RWMutex
+	warmers []*warming
+	hotKeys int
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// warming is a Warmer with the hot keys read since its last refresh
+type warming struct {
+	Warmer
+	mu  sync.Mutex
+	hot map[string]bool
+}
+
+func init() {
+	instance = newCacheWarmup()
+	server.RegisterService(instance, server.Low, "postgres", "caches")
+	cache.OnRead(func(remote bool, key string) {
+		instance.read(remote, key)
+	})
+}
+
+func newCacheWarmup() *cacheWarmup {
+	return &cacheWarmup{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Register warmer, in the Init of a repository
+func Register(warmer Warmer) {
+	if warmer.Refresh <= 0 && warmer.TTL > 0 {
+		warmer.Refresh = warmer.TTL * 3 / 4
+	}
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	instance.warmers = append(instance.warmers, &warming{
+		Warmer: warmer,
+		hot:    make(map[string]bool),
+	})
+}
+
+func (c *cacheWarmup) Init() error {
+	viper.SetDefault("cache.warmup.enabled", true)
+	viper.SetDefault("cache.warmup.hotkeys", 1000)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hotKeys = viper.GetInt("cache.warmup.hotkeys")
+	return nil
+}
+
+func (c *cacheWarmup) OnConfig() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hotKeys = viper.GetInt("cache.warmup.hotkeys")
+}
+
+// Run the warmers once postgres is migrated, until stopped.
+func (c *cacheWarmup) Run(ctx context.Context) error {
+	defer close(c.done)
+	if !viper.GetBool("cache.warmup.enabled") {
+		return nil
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+	if err := waitMigrated(runCtx); err != nil {
+		select {
+		case <-c.stop:
+		default:
+			log.WithField("Error", err).Warn("Cache warmup not started, postgres was not migrated")
+		}
+		return nil
+	}
+
+	c.mu.RLock()
+	warmers := c.warmers
+	c.mu.RUnlock()
+	log.WithField("Warmers", len(warmers)).Info("Cache warmup started")
+	var waitGroup sync.WaitGroup
+	for _, w := range warmers {
+		waitGroup.Add(1)
+		go func(w *warming) {
+			defer waitGroup.Done()
+			w.run(runCtx)
+		}(w)
+	}
+	waitGroup.Wait()
+	return nil
+}
+
+// Stop the warmers and wait for the keys being loaded.
+func (c *cacheWarmup) Stop(ctx context.Context) error {
+	c.once.Do(func() { close(c.stop) })
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// read records key as hot for the warmers of its pattern
+func (c *cacheWarmup) read(remote bool, key string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, w := range c.warmers {
+		if w.Remote != remote {
+			continue
+		}
+		if matched, _ := path.Match(w.Pattern, key); !matched {
+			continue
+		}
+		w.mu.Lock()
+		if len(w.hot) < c.hotKeys {
+			w.hot[key] = true
+		}
+		w.mu.Unlock()
+	}
+}
+
+// run loads the keys missing from the cache, then refreshes the keys and the
+// hot keys every Refresh until ctx is done.
+func (w *warming) run(ctx context.Context) {
+	keys := w.keys(ctx)
+	start := time.Now()
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := cache.Warm(ctx, w.Remote, key, w.TTL, w.Load); err != nil {
+			log.WithFields(log.Fields{
+				"Warmer": w.Name,
+				"Key":    key,
+				"Error":  err,
+			}).Error("Warming cache key failed")
+		}
+	}
+	log.WithFields(log.Fields{
+		"Warmer":   w.Name,
+		"Keys":     len(keys),
+		"Duration": time.Since(start),
+	}).Info("Cache warmed")
+	if w.Refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.refresh(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// refresh the keys and the keys read since the last refresh
+func (w *warming) refresh(ctx context.Context) {
+	keys := make(map[string]bool)
+	for _, key := range w.keys(ctx) {
+		keys[key] = true
+	}
+	w.mu.Lock()
+	for key := range w.hot {
+		keys[key] = true
+	}
+	w.hot = make(map[string]bool)
+	w.mu.Unlock()
+
+	for key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := cache.Reload(ctx, w.Remote, key, w.TTL, w.Load); err != nil {
+			log.WithFields(log.Fields{
+				"Warmer": w.Name,
+				"Key":    key,
+				"Error":  err,
+			}).Error("Refreshing cache key failed")
+		}
+	}
+}
+
+// keys loaded whether read or not
+func (w *warming) keys(ctx context.Context) []string {
+	if w.Keys == nil {
+		if strings.ContainsAny(w.Pattern, "*?[") {
+			return nil
+		}
+		return []string{w.Pattern}
+	}
+	keys, err := w.Keys(ctx)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Warmer": w.Name,
+			"Error":  err,
+		}).Error("Listing cache keys to warm failed")
+	}
+	return keys
+}
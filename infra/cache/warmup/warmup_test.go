@@ -0,0 +1,186 @@
+package warmup
+
+import (
+	"context"
+	"go-microservice/infra/cache"
+	"go-microservice/infra/server"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TestMain runs the tests on the in-memory cache, without a remote one
+func TestMain(m *testing.M) {
+	viper.Set("cache.remote", "none")
+	services, err := server.GetServices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, service := range services {
+		if service.Name == "caches" {
+			if err := service.Instance.Init(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	os.Exit(m.Run())
+}
+
+// useWarmup with the warmers as the only ones for the test, postgres is
+// migrated once migrated is closed.
+func useWarmup(t *testing.T, migrated chan struct{}, warmers ...Warmer) *cacheWarmup {
+	if err := cache.Flush(false); err != nil {
+		t.Fatal(err)
+	}
+	previous, previousWait := instance, waitMigrated
+	instance = newCacheWarmup()
+	waitMigrated = func(ctx context.Context) error {
+		select {
+		case <-migrated:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, warmer := range warmers {
+		Register(warmer)
+	}
+	c := instance
+	if err := c.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := c.Stop(ctx); err != nil {
+			t.Errorf("stopping the warmup failed: %v", err)
+		}
+		instance, waitMigrated = previous, previousWait
+	})
+	return c
+}
+
+// loader sending the keys it loads to loaded
+func loader(loaded chan string, value string) cache.Loader {
+	return func(ctx context.Context, key string) (interface{}, error) {
+		loaded <- key
+		return value, nil
+	}
+}
+
+// expectLoad of key, waiting for the warmer
+func expectLoad(t *testing.T, loaded chan string, key string) {
+	t.Helper()
+	select {
+	case got := <-loaded:
+		if got != key {
+			t.Fatalf("expected %s loaded, got %s", key, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("%s not loaded", key)
+	}
+}
+
+func TestWarmOnceMigrated(t *testing.T) {
+	migrated := make(chan struct{})
+	loaded := make(chan string, 10)
+	c := useWarmup(t, migrated, Warmer{
+		Name:    "migrated",
+		Pattern: "migrated:*",
+		Keys: func(ctx context.Context) ([]string, error) {
+			return []string{"migrated:1", "migrated:2"}, nil
+		},
+		Load: loader(loaded, "warm"),
+		TTL:  time.Hour,
+	})
+	go c.Run(context.Background())
+
+	select {
+	case key := <-loaded:
+		t.Fatalf("%s loaded before postgres was migrated", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(migrated)
+	expectLoad(t, loaded, "migrated:1")
+	expectLoad(t, loaded, "migrated:2")
+
+	// read from the cache without loading
+	var value string
+	failing := func(ctx context.Context, key string) (interface{}, error) {
+		t.Errorf("%s loaded again", key)
+		return nil, cache.ErrNotFound
+	}
+	if err := cache.GetOrLoadCtx(context.Background(), false, "migrated:2", &value, time.Hour, failing); err != nil || value != "warm" {
+		t.Fatalf("expected the warmed value, got %q, %v", value, err)
+	}
+}
+
+func TestRefreshHotKeys(t *testing.T) {
+	migrated := make(chan struct{})
+	close(migrated)
+	refreshed := make(chan string, 10)
+	c := useWarmup(t, migrated, Warmer{
+		Name:    "hot",
+		Pattern: "hot:*",
+		Load:    loader(refreshed, "refreshed"),
+		TTL:     time.Hour,
+		Refresh: 20 * time.Millisecond,
+	})
+	go c.Run(context.Background())
+
+	var value string
+	read := make(chan string, 1)
+	if err := cache.GetOrLoadCtx(context.Background(), false, "hot:1", &value, time.Hour, loader(read, "read")); err != nil || value != "read" {
+		t.Fatalf("expected the value loaded by the read, got %q, %v", value, err)
+	}
+	// refreshed on the next tick by the warmer
+	expectLoad(t, refreshed, "hot:1")
+}
+
+func TestHotKeysLimit(t *testing.T) {
+	viper.Set("cache.warmup.hotkeys", 2)
+	t.Cleanup(func() { viper.Set("cache.warmup.hotkeys", nil) })
+	c := useWarmup(t, make(chan struct{}), Warmer{
+		Name:    "limited",
+		Pattern: "limited:*",
+		Load:    loader(make(chan string, 10), "value"),
+		TTL:     time.Hour,
+	})
+	// waits for postgres until stopped
+	go c.Run(context.Background())
+
+	for _, key := range []string{"limited:1", "limited:2", "limited:3", "limited:4"} {
+		var value string
+		if err := cache.GetOrLoadCtx(context.Background(), false, key, &value, time.Hour, loader(make(chan string, 1), "value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := c.warmers[0]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.hot) != 2 {
+		t.Fatalf("expected 2 hot keys kept, got %d", len(w.hot))
+	}
+}
+
+func TestStopWhenDisabled(t *testing.T) {
+	viper.Set("cache.warmup.enabled", false)
+	t.Cleanup(func() { viper.Set("cache.warmup.enabled", nil) })
+	c := useWarmup(t, make(chan struct{}))
+	waitMigrated = func(ctx context.Context) error {
+		t.Error("waiting for postgres while disabled")
+		return nil
+	}
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Stop(ctx); err != nil {
+		t.Fatalf("Stop of a disabled warmup failed: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jinzhu/gorm"
+)
+
+// contextDB runs the statements of the shared connection pool with ctx, so a
+// cancelled request aborts its query with the error of ctx. Transactions
+// begin with ctx as well and are rolled back once it is done. A row query
+// aborted returns the error of the driver, *sql.Row cannot be wrapped.
+type contextDB struct {
+	db  *sql.DB
+	ctx context.Context
+}
+
+func (c *contextDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	result, err := c.db.ExecContext(c.ctx, query, args...)
+	return result, c.err(err)
+}
+
+func (c *contextDB) Prepare(query string) (*sql.Stmt, error) {
+	stmt, err := c.db.PrepareContext(c.ctx, query)
+	return stmt, c.err(err)
+}
+
+func (c *contextDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := c.db.QueryContext(c.ctx, query, args...)
+	return rows, c.err(err)
+}
+
+func (c *contextDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.db.QueryRowContext(c.ctx, query, args...)
+}
+
+func (c *contextDB) Begin() (*sql.Tx, error) {
+	return c.BeginTx(c.ctx, nil)
+}
+
+// BeginTx ignores the context gorm passes, gorm begins with
+// context.Background.
+func (c *contextDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	tx, err := c.db.BeginTx(c.ctx, opts)
+	return tx, c.err(err)
+}
+
+// err of ctx in place of that of the driver once ctx is done, lib/pq tells
+// the statement cancelled by the server.
+func (c *contextDB) err(err error) error {
+	if err != nil && c.ctx.Err() != nil {
+		return c.ctx.Err()
+	}
+	return err
+}
+
+// bind a db to the statements of connection run with ctx. Opening on an
+// existing pool neither connects nor pings, it only allocates the gorm state.
+func bind(ctx context.Context, connection *gorm.DB) (*gorm.DB, error) {
+	db, err := gorm.Open(connection.Dialect().GetName(), &contextDB{db: connection.DB(), ctx: ctx})
+	if err != nil {
+		return nil, err
+	}
+	configure(db)
+	return db.Set(contextKey, ctx), nil
+}
+
+// scopeContext is the ctx the db of scope was bound to by WithContext
+func scopeContext(scope *gorm.Scope) (context.Context, bool) {
+	value, ok := scope.Get(contextKey)
+	if !ok {
+		return nil, false
+	}
+	ctx, ok := value.(context.Context)
+	return ctx, ok
+}
@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// blockingConn runs every statement until its ctx is done, then fails like
+// lib/pq does for a statement cancelled by the server
+type blockingConn struct{}
+
+type blockingDriver struct{}
+
+var errCancelledByServer = errors.New("pq: canceling statement due to user request")
+
+func init() {
+	sql.Register("blocking", blockingDriver{})
+}
+
+func (blockingDriver) Open(name string) (driver.Conn, error) {
+	return blockingConn{}, nil
+}
+
+func (blockingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (blockingConn) Close() error {
+	return nil
+}
+
+func (blockingConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (blockingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	<-ctx.Done()
+	return nil, errCancelledByServer
+}
+
+func (blockingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	<-ctx.Done()
+	return nil, errCancelledByServer
+}
+
+// cancelledSoon is a ctx cancelled once the statement is running
+func cancelledSoon(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestWithContextAbortsStatement(t *testing.T) {
+	pool, err := sql.Open("blocking", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	connection, err := gorm.Open("postgres", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bind(cancelledSoon(t), connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Raw("SELECT pg_sleep(5)").Rows(); err != context.Canceled {
+		t.Fatalf("expected the query aborted with context.Canceled, got %v", err)
+	}
+
+	db, err = bind(cancelledSoon(t), connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("SELECT pg_sleep(5)").Error; err != context.Canceled {
+		t.Fatalf("expected the statement aborted with context.Canceled, got %v", err)
+	}
+}
+
+// TestWithContextAbortsPgSleep runs on the postgres of MS_POSTGRES_TEST_DSN,
+// like "host=127.0.0.1 user=postgres dbname=postgres sslmode=disable"
+func TestWithContextAbortsPgSleep(t *testing.T) {
+	dsn := os.Getenv("MS_POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MS_POSTGRES_TEST_DSN not set")
+	}
+	connection, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	db, err := bind(cancelledSoon(t), connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	_, err = db.Raw("SELECT pg_sleep(5)").Rows()
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("query ran %s after being cancelled", elapsed)
+	}
+}
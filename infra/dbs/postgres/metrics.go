@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"go-microservice/infra/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dbStatsCollector exposes the connection pool stats of the gorm connection
+type dbStatsCollector struct {
+	maxOpen      *prometheus.Desc
+	open         *prometheus.Desc
+	inUse        *prometheus.Desc
+	idle         *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	maxIdle      *prometheus.Desc
+	maxLifetime  *prometheus.Desc
+}
+
+func init() {
+	metrics.Register(newDBStatsCollector())
+}
+
+func newDBStatsCollector() *dbStatsCollector {
+	return &dbStatsCollector{
+		maxOpen:      prometheus.NewDesc("postgres_max_open_connections", "Maximum number of open connections to the database.", nil, nil),
+		open:         prometheus.NewDesc("postgres_open_connections", "The number of established connections both in use and idle.", nil, nil),
+		inUse:        prometheus.NewDesc("postgres_in_use_connections", "The number of connections currently in use.", nil, nil),
+		idle:         prometheus.NewDesc("postgres_idle_connections", "The number of idle connections.", nil, nil),
+		waitCount:    prometheus.NewDesc("postgres_wait_count_total", "The total number of connections waited for.", nil, nil),
+		waitDuration: prometheus.NewDesc("postgres_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", nil, nil),
+		maxIdle:      prometheus.NewDesc("postgres_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", nil, nil),
+		maxLifetime:  prometheus.NewDesc("postgres_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", nil, nil),
+	}
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdle
+	ch <- c.maxLifetime
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	db, err := DB()
+	if err != nil {
+		return
+	}
+	stats := db.DB().Stats()
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdle, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetime, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"go-microservice/infra/metrics"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -62,6 +63,7 @@ func startMigrations() error {
 		_, exists := logMap[m.ID()]
 		if exists {
 			log.WithField("ID", m.ID()).Debug("Skipping migration, already executed")
+			metrics.Migrations.WithLabelValues("skipped").Inc()
 			continue
 		}
 		sql := m.Sql()
@@ -75,8 +77,10 @@ func startMigrations() error {
 			err := executeMigration(m, tx)
 			if err != nil {
 				record.Error = err.Error()
+				metrics.Migrations.WithLabelValues("failed").Inc()
 			} else {
 				record.Success = true
+				metrics.Migrations.WithLabelValues("success").Inc()
 			}
 			if err := tx.Create(&record).Error; err != nil {
 				return err
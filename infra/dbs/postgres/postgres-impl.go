@@ -1,9 +1,11 @@
 package postgres
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"go-microservice/infra/server"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -21,17 +23,31 @@ type config struct {
 }
 
 type postgres struct {
+	mu         sync.RWMutex
 	connection *gorm.DB
 	config     config
+	stopped    bool
+	migrating  bool
+	migrated   chan struct{}
+	once       sync.Once
 }
 
 var (
 	instance         *postgres
 	ErrNotConfigured = errors.New("Postgres is not configured")
 	ErrNotConnected  = errors.New("Postgres is not connected")
+	ErrMigrating     = errors.New("Postgres migrations are running")
 )
 
 func connect() {
+	instance.mu.Lock()
+	stopped := instance.stopped
+	// Not ready until migrated, set before the connection is visible
+	instance.migrating = true
+	instance.mu.Unlock()
+	if stopped {
+		return
+	}
 	if err := instance.connect(); err != nil {
 		log.WithField("Error", err).Errorln("Postgres connection failed")
 		go func() {
@@ -44,13 +60,18 @@ func connect() {
 	if err := startMigrations(); err != nil {
 		log.WithField("error", err).Fatal("Migration failed")
 	}
+	instance.mu.Lock()
+	instance.migrating = false
+	instance.mu.Unlock()
+	instance.once.Do(func() { close(instance.migrated) })
 }
 
 func init() {
 	instance = &postgres{
 		connection: nil,
+		migrated:   make(chan struct{}),
 	}
-	server.RegisterService(instance, server.High)
+	server.RegisterService(instance, server.High, "tracing")
 }
 
 func (c *postgres) Init() (err error) {
@@ -75,15 +96,58 @@ func (c *postgres) connect() error {
 	if connection, err = gorm.Open("postgres", args); err != nil {
 		return err
 	}
+	configure(connection)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		connection.Close()
+		return ErrNotConnected
+	}
+	c.connection = connection
+	return nil
+}
+
+// db is the connection, nil until connected
+func (c *postgres) db() *gorm.DB {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connection
+}
+
+func configure(connection *gorm.DB) {
 	if viper.Get("mode") == "prod" {
 		connection.LogMode(false)
 	} else {
 		connection.LogMode(true)
 	}
-	c.connection = connection
-	c.connection.SingularTable(true)
-	return nil
+	connection.SingularTable(true)
 }
 
 func (c *postgres) OnConfig() {
 }
+
+// CheckHealth reports not ready until connected and migrated
+func (c *postgres) CheckHealth(ctx context.Context) error {
+	c.mu.RLock()
+	connection, migrating := c.connection, c.migrating
+	c.mu.RUnlock()
+	if connection == nil {
+		return ErrNotConnected
+	}
+	if migrating {
+		return ErrMigrating
+	}
+	return connection.DB().PingContext(ctx)
+}
+
+func (c *postgres) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	c.stopped = true
+	connection := c.connection
+	c.mu.Unlock()
+	if connection == nil {
+		return nil
+	}
+	log.Info("Closing postgres connection")
+	return connection.Close()
+}
@@ -1,16 +1,92 @@
 package postgres
 
 import (
+	"context"
+
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 )
 
 // Use postgres.DB() for accessing records in your service
 func DB() (*gorm.DB, error) {
-	if instance.connection == nil {
+	connection := instance.db()
+	if connection == nil {
 		return nil, ErrNotConnected
 	}
-	return instance.connection, nil
+	return connection, nil
+}
+
+// Use postgres.WithContext() for accessing records on behalf of a request,
+// the statements are traced as part of the request and aborted once ctx is
+// done. Its DB() panics like that of a transaction, use postgres.DB() for the
+// connection pool.
+func WithContext(ctx context.Context) (*gorm.DB, error) {
+	db, err := DB()
+	if err != nil {
+		return nil, err
+	}
+	return bind(ctx, db)
+}
+
+// Transaction runs fc in a transaction begun with ctx, committed when fc
+// returns nil and rolled back otherwise or once ctx is done.
+func Transaction(ctx context.Context, fc func(tx *gorm.DB) error) (err error) {
+	db, err := WithContext(ctx)
+	if err != nil {
+		return err
+	}
+	tx := db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	err = fc(tx)
+	if err == nil {
+		err = tx.Commit().Error
+	}
+	panicked = false
+	return err
+}
+
+// Context returns the ctx db was bound to by WithContext, or
+// context.Background() for DB().
+func Context(db *gorm.DB) context.Context {
+	if value, ok := db.Get(contextKey); ok {
+		if ctx, ok := value.(context.Context); ok {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// WaitMigrated blocks until connected and the migrations ran, or ctx is done.
+func WaitMigrated(ctx context.Context) error {
+	select {
+	case <-instance.migrated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MigrationLogs returns the migration_log, oldest first
+func MigrationLogs(ctx context.Context) ([]MigrationLog, error) {
+	db, err := WithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logItems := make([]MigrationLog, 0)
+	if !db.HasTable(new(MigrationLog)) {
+		return logItems, nil
+	}
+	err = db.Order("id").Find(&logItems).Error
+	return logItems, err
 }
 
 //Use postgres.AddMigration() for all schema migrations in your  service within  "Service Interface"
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"go-microservice/infra/tracing"
+
+	"github.com/jinzhu/gorm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contextKey = "postgres:context"
+	spanKey    = "postgres:span"
+)
+
+func init() {
+	registerTracing(gorm.DefaultCallback)
+}
+
+// registerTracing on the default callbacks traces every connection including
+// the ones bound to a context by WithContext.
+func registerTracing(callback *gorm.Callback) {
+	callback.Create().Before("gorm:create").Register("tracing:before_create", beforeStatement("INSERT"))
+	callback.Create().After("gorm:create").Register("tracing:after_create", afterStatement)
+	callback.Query().Before("gorm:query").Register("tracing:before_query", beforeStatement("SELECT"))
+	callback.Query().After("gorm:query").Register("tracing:after_query", afterStatement)
+	callback.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", beforeStatement("SELECT"))
+	callback.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", afterStatement)
+	callback.Update().Before("gorm:update").Register("tracing:before_update", beforeStatement("UPDATE"))
+	callback.Update().After("gorm:update").Register("tracing:after_update", afterStatement)
+	callback.Delete().Before("gorm:delete").Register("tracing:before_delete", beforeStatement("DELETE"))
+	callback.Delete().After("gorm:delete").Register("tracing:after_delete", afterStatement)
+}
+
+func beforeStatement(operation string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		ctx, ok := scopeContext(scope)
+		if !ok {
+			return
+		}
+		table := scope.TableName()
+		_, span := tracing.Start(ctx, "postgres "+operation+" "+table,
+			semconv.DBSystemPostgres,
+			semconv.DBOperationKey.String(operation),
+			semconv.DBNameKey.String(instance.config.DBname),
+			attribute.String("db.sql.table", table),
+		)
+		scope.InstanceSet(spanKey, span)
+	}
+}
+
+func afterStatement(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	span.SetAttributes(
+		semconv.DBStatementKey.String(scope.SQL),
+		attribute.Int64("db.rows_affected", scope.DB().RowsAffected),
+	)
+	err := scope.DB().Error
+	if gorm.IsRecordNotFoundError(err) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
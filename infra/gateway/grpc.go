@@ -8,7 +8,9 @@ import (
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var rpcInstance *GRPC
@@ -21,7 +23,7 @@ type GRPC struct {
 
 func init() {
 	rpcInstance = &GRPC{}
-	server.RegisterService(rpcInstance, server.High)
+	server.RegisterService(rpcInstance, server.High, "tracing")
 }
 
 func (c *GRPC) Init() (err error) {
@@ -37,7 +39,11 @@ func (c *GRPC) Init() (err error) {
 		}).Error("Grpc server failed to listen")
 		return err
 	}
-	c.grpcServer = grpc.NewServer()
+	c.grpcServer = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), metricsUnaryInterceptor),
+		grpc.ChainStreamInterceptor(otelgrpc.StreamServerInterceptor(), metricsStreamInterceptor),
+	)
+	grpc_health_v1.RegisterHealthServer(c.grpcServer, &healthService{})
 	return nil
 }
 
@@ -53,3 +59,19 @@ func (c *GRPC) Run(ctx context.Context) error {
 	log.WithField("Port", c.grpcPort).Info("Grpc listening...")
 	return c.grpcServer.Serve(c.listener)
 }
+
+func (c *GRPC) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		log.Infoln("Stopping Grpc")
+		c.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		c.grpcServer.Stop()
+		return ctx.Err()
+	}
+}
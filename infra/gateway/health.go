@@ -0,0 +1,114 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"go-microservice/infra/server"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+	healthWatchPeriod  = 5 * time.Second
+)
+
+type healthResponse struct {
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Services map[string]string `json:"services,omitempty"`
+}
+
+// healthService implements the standard grpc.health.v1.Health service, the
+// empty service name reports the readiness of the whole server.
+type healthService struct{}
+
+func (h *healthService) Check(ctx context.Context, request *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	servingStatus, found := h.servingStatus(ctx, request.GetService())
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", request.GetService())
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+func (h *healthService) Watch(request *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchPeriod)
+	defer ticker.Stop()
+
+	lastStatus := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		servingStatus, found := h.servingStatus(stream.Context(), request.GetService())
+		if !found {
+			servingStatus = grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if servingStatus != lastStatus {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: servingStatus}); err != nil {
+				return err
+			}
+			lastStatus = servingStatus
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *healthService) servingStatus(ctx context.Context, service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, bool) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	var err error
+	if service == "" {
+		_, err = server.Ready(ctx)
+	} else {
+		var found bool
+		if found, err = server.ServiceReady(ctx, service); !found {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, false
+		}
+	}
+	if err != nil {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, true
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING, true
+}
+
+// livenessHandler serves /healthz
+func livenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, server.Live(), nil)
+}
+
+// readinessHandler serves /readyz with the health of every service
+func readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	statuses, err := server.Ready(ctx)
+	writeHealth(w, err, statuses)
+}
+
+func writeHealth(w http.ResponseWriter, err error, statuses map[string]error) {
+	response := healthResponse{Status: "ok"}
+	code := http.StatusOK
+	if err != nil {
+		response.Status = "unavailable"
+		response.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	if len(statuses) > 0 {
+		response.Services = make(map[string]string, len(statuses))
+		for name, serviceErr := range statuses {
+			response.Services[name] = "ok"
+			if serviceErr != nil {
+				response.Services[name] = serviceErr.Error()
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
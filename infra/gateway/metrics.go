@@ -0,0 +1,95 @@
+package gateway
+
+import (
+	"context"
+	"go-microservice/infra/metrics"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+func metricsUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	observeRPC("unary", info.FullMethod, start, err)
+	return resp, err
+}
+
+func metricsStreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	rpcType := "bidi_stream"
+	if !info.IsClientStream {
+		rpcType = "server_stream"
+	} else if !info.IsServerStream {
+		rpcType = "client_stream"
+	}
+	observeRPC(rpcType, info.FullMethod, start, err)
+	return err
+}
+
+func observeRPC(rpcType string, fullMethod string, start time.Time, err error) {
+	service, method := splitMethodName(fullMethod)
+	metrics.GrpcHandled.WithLabelValues(rpcType, service, method, status.Code(err).String()).Inc()
+	metrics.GrpcHandlingSeconds.WithLabelValues(rpcType, service, method).Observe(time.Since(start).Seconds())
+}
+
+// splitMethodName splits /package.Service/Method
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// instrumentHandler records the requests served by the gateway
+func instrumentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		path := routeLabel(r.URL.Path)
+		metrics.HttpRequests.WithLabelValues(r.Method, path, strconv.Itoa(recorder.status)).Inc()
+		metrics.HttpRequestSeconds.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+	})
+}
+
+// routeLabel keeps the label cardinality bounded, /api/users/42 is recorded
+// as /api/users and everything outside /api and /debug as the static OpenAPI
+// assets.
+func routeLabel(path string) string {
+	if !strings.HasPrefix(path, "/api") && !strings.HasPrefix(path, "/debug/") {
+		switch path {
+		case "/healthz", "/readyz", "/metrics":
+			return path
+		}
+		return "/"
+	}
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	return "/" + strings.Join(segments, "/")
+}
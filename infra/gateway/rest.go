@@ -3,6 +3,7 @@ package gateway
 import (
 	"context"
 	"fmt"
+	"go-microservice/infra/metrics"
 	"go-microservice/infra/server"
 	"mime"
 	"net/http"
@@ -12,6 +13,8 @@ import (
 	"github.com/rakyll/statik/fs"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
 
 	_ "go-microservice/statik"
@@ -24,12 +27,13 @@ type REST struct {
 	grpcPort   int
 	connection *grpc.ClientConn
 	mux        *runtime.ServeMux
+	httpServer *http.Server
 }
 
 func init() {
 	restInstance = &REST{}
-	//Set it High or low based on your requirement
-	server.RegisterService(restInstance, server.Low)
+	//Dials the Grpc server, hence initialized after it
+	server.RegisterService(restInstance, server.Low, "GRPC", "tracing")
 }
 
 func (c *REST) Init() (err error) {
@@ -38,7 +42,10 @@ func (c *REST) Init() (err error) {
 	c.grpcPort = viper.GetInt("grpc")
 	c.httpPort = viper.GetInt("http")
 	address := fmt.Sprintf("dns:///0.0.0.0:%d", c.grpcPort)
-	c.connection, err = grpc.DialContext(context.Background(), address, grpc.WithInsecure())
+	// Trace context extracted from the HTTP headers is forwarded to Grpc
+	c.connection, err = grpc.DialContext(context.Background(), address, grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"Error": err,
@@ -46,13 +53,7 @@ func (c *REST) Init() (err error) {
 		return err
 	}
 	c.mux = runtime.NewServeMux()
-	return err
-}
 
-func (c *REST) OnConfig() {
-}
-
-func (c *REST) Run(ctx context.Context) error {
 	handler, err := openAPIHandler()
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -60,24 +61,51 @@ func (c *REST) Run(ctx context.Context) error {
 		}).Error("OpenAPI creation failed")
 		return err
 	}
-	address := fmt.Sprintf("0.0.0.0:%d", c.httpPort)
-	server := &http.Server{
-		Addr: address,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasPrefix(r.URL.Path, "/api") {
+	promHandler := metrics.Handler()
+	c.httpServer = &http.Server{
+		Addr: fmt.Sprintf("0.0.0.0:%d", c.httpPort),
+		Handler: otelhttp.NewHandler(instrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/api"), strings.HasPrefix(r.URL.Path, "/debug/"):
 				c.mux.ServeHTTP(w, r)
-				return
+			case r.URL.Path == "/healthz":
+				livenessHandler(w, r)
+			case r.URL.Path == "/readyz":
+				readinessHandler(w, r)
+			case r.URL.Path == "/metrics" && !metrics.Dedicated():
+				promHandler.ServeHTTP(w, r)
+			default:
+				handler.ServeHTTP(w, r)
 			}
-			handler.ServeHTTP(w, r)
-		}),
+		})), "gateway", otelhttp.WithFilter(func(r *http.Request) bool {
+			return strings.HasPrefix(r.URL.Path, "/api")
+		})),
 	}
+	return nil
+}
+
+func (c *REST) OnConfig() {
+}
+
+func (c *REST) Run(ctx context.Context) error {
 	go func() {
+		// drained by Stop, closes the connections left when cancelled otherwise
 		<-ctx.Done()
-		log.Info("Stopping OpenAPI")
-		server.Shutdown(ctx)
+		c.httpServer.Close()
 	}()
 	log.WithField("Port", c.httpPort).Info("OpenAPI listening...")
-	return server.ListenAndServe()
+	if err := c.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (c *REST) Stop(ctx context.Context) error {
+	log.Info("Stopping OpenAPI")
+	if err := c.httpServer.Shutdown(ctx); err != nil {
+		return err
+	}
+	return c.connection.Close()
 }
 
 func openAPIHandler() (http.Handler, error) {
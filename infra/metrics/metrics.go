@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"go-microservice/infra/server"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+var (
+	instance *metricsServer
+	registry = prometheus.NewRegistry()
+
+	GrpcHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_server_handled_total",
+		Help: "Total number of RPCs completed on the server.",
+	}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"})
+
+	GrpcHandlingSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_server_handling_seconds",
+		Help:    "Histogram of RPC handling latency on the server.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"grpc_type", "grpc_service", "grpc_method"})
+
+	HttpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests served by the gateway.",
+	}, []string{"method", "path", "code"})
+
+	HttpRequestSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Histogram of HTTP request latency on the gateway.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "path"})
+
+	BusMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bus_messages_total",
+		Help: "Total number of messages dispatched or published on the bus.",
+	}, []string{"kind", "message", "status"})
+
+	BusMessageSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bus_message_duration_seconds",
+		Help:    "Histogram of bus handler and listener latency.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind", "message"})
+
+	CacheOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_operations_total",
+		Help: "Total number of cache operations by result (hit, miss, ok, error).",
+	}, []string{"backend", "operation", "result"})
+
+	CacheEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Total number of entries leaving the in-memory cache by reason (expired, capacity, deleted).",
+	}, []string{"reason"})
+
+	Migrations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "postgres_migrations_total",
+		Help: "Total number of postgres migrations by result (success, failed, skipped).",
+	}, []string{"result"})
+)
+
+// metricsServer serves /metrics on a dedicated port when metrics.port is
+// configured, otherwise the REST gateway serves it.
+type metricsServer struct {
+	port       int
+	httpServer *http.Server
+}
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		GrpcHandled,
+		GrpcHandlingSeconds,
+		HttpRequests,
+		HttpRequestSeconds,
+		BusMessages,
+		BusMessageSeconds,
+		CacheOperations,
+		CacheEvictions,
+		Migrations,
+	)
+	instance = &metricsServer{}
+	server.RegisterService(instance, server.Low)
+}
+
+// Register adds collectors owned by other packages, e.g. connection pool stats
+func Register(collector prometheus.Collector) {
+	if err := registry.Register(collector); err != nil {
+		log.WithField("Error", err).Error("Registering metrics collector failed")
+	}
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Dedicated reports whether metrics are served on their own port
+func Dedicated() bool {
+	return instance.port != 0
+}
+
+func (c *metricsServer) Init() (err error) {
+	viper.SetDefault("metrics.port", 0)
+	c.port = viper.GetInt("metrics.port")
+	if c.port == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	c.httpServer = &http.Server{
+		Addr:    fmt.Sprintf("0.0.0.0:%d", c.port),
+		Handler: mux,
+	}
+	return nil
+}
+
+func (c *metricsServer) OnConfig() {
+}
+
+func (c *metricsServer) Run(ctx context.Context) error {
+	if c.httpServer == nil {
+		<-ctx.Done()
+		return nil
+	}
+	go func() {
+		<-ctx.Done()
+		c.httpServer.Close()
+	}()
+	log.WithField("Port", c.port).Info("Metrics listening...")
+	if err := c.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (c *metricsServer) Stop(ctx context.Context) error {
+	if c.httpServer == nil {
+		return nil
+	}
+	log.Info("Stopping Metrics")
+	return c.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	ErrNotStarted    = errors.New("Services are not started")
+	ErrShuttingDown  = errors.New("Server is shutting down")
+	ErrServiceFailed = errors.New("Background service failed")
+)
+
+const (
+	stateStarting int32 = iota
+	stateRunning
+	stateShuttingDown
+	stateFailed
+)
+
+var state = stateStarting
+
+// HealthChecker is implemented by services which can report whether they are
+// ready to serve requests. CheckHealth returns nil when ready.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// Live reports whether the server is alive, it fails once a background
+// service has failed and the server can not recover.
+func Live() error {
+	if atomic.LoadInt32(&state) == stateFailed {
+		return ErrServiceFailed
+	}
+	return nil
+}
+
+// Ready reports whether the server is ready to serve requests along with the
+// health of every HealthChecker service keyed by service name. The server is
+// not ready before all services are initialized and once shutdown starts.
+func Ready(ctx context.Context) (map[string]error, error) {
+	statuses := make(map[string]error)
+	for _, descriptor := range services {
+		if checker, ok := descriptor.Instance.(HealthChecker); ok {
+			statuses[descriptor.Name] = checker.CheckHealth(ctx)
+		}
+	}
+
+	if err := serverReady(); err != nil {
+		return statuses, err
+	}
+	for _, err := range statuses {
+		if err != nil {
+			return statuses, err
+		}
+	}
+	return statuses, nil
+}
+
+// ServiceReady reports whether the named service is ready to serve requests.
+// found is false when no service is registered with the name.
+func ServiceReady(ctx context.Context, name string) (found bool, err error) {
+	for _, descriptor := range services {
+		if descriptor.Name != name {
+			continue
+		}
+		if err := serverReady(); err != nil {
+			return true, err
+		}
+		if checker, ok := descriptor.Instance.(HealthChecker); ok {
+			return true, checker.CheckHealth(ctx)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func serverReady() error {
+	switch atomic.LoadInt32(&state) {
+	case stateStarting:
+		return ErrNotStarted
+	case stateShuttingDown:
+		return ErrShuttingDown
+	case stateFailed:
+		return ErrServiceFailed
+	}
+	return nil
+}
+
+func setState(newState int32) {
+	// A failed server stays failed
+	for {
+		current := atomic.LoadInt32(&state)
+		if current == stateFailed {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&state, current, newState) {
+			return
+		}
+	}
+}
@@ -2,14 +2,24 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"sort"
+	"strings"
+)
+
+var (
+	ErrDuplicateService  = errors.New("Service already registered")
+	ErrUnknownDependency = errors.New("Service dependency not registered")
+	ErrDependencyCycle   = errors.New("Service dependency cycle")
 )
 
 type Descriptor struct {
 	Name         string
 	Instance     Service
 	InitPriority Priority
+	DependsOn    []string
 }
 
 type Service interface {
@@ -21,6 +31,16 @@ type BackgroundService interface {
 	Run(ctx context.Context) error
 }
 
+// Stopper is implemented by services which hold resources to be released on
+// shutdown. Stop is called in reverse initialization order and must return
+// once ctx is done.
+type Stopper interface {
+	Stop(ctx context.Context) error
+}
+
+// Priority orders the services of a dependency level, those of a higher
+// priority are initialized before the others. Use DependsOn to order services
+// which need each other.
 type Priority int
 
 const (
@@ -33,11 +53,14 @@ const (
 
 var services []*Descriptor
 
-func RegisterService(instance Service, priority Priority) {
+// RegisterService registers the service under its type name. dependsOn lists
+// the names of the services which must be initialized before this one.
+func RegisterService(instance Service, priority Priority, dependsOn ...string) {
 	services = append(services, &Descriptor{
 		Name:         reflect.TypeOf(instance).Elem().Name(),
 		Instance:     instance,
 		InitPriority: priority,
+		DependsOn:    dependsOn,
 	})
 }
 
@@ -45,9 +68,71 @@ func Register(descriptor *Descriptor) {
 	services = append(services, descriptor)
 }
 
-func GetServices() []*Descriptor {
-	sort.Slice(services, func(i, j int) bool {
+// GetServices returns the registered services in dependency order, every
+// service comes after all of its dependencies. Services without a dependency
+// relation are ordered by priority. Returns an error when a dependency is not
+// registered or the dependencies form a cycle.
+func GetServices() ([]*Descriptor, error) {
+	sort.SliceStable(services, func(i, j int) bool {
 		return services[i].InitPriority > services[j].InitPriority
 	})
-	return services
+
+	byName := make(map[string]*Descriptor, len(services))
+	for _, descriptor := range services {
+		if _, exists := byName[descriptor.Name]; exists {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateService, descriptor.Name)
+		}
+		byName[descriptor.Name] = descriptor
+	}
+	for _, descriptor := range services {
+		for _, dependency := range descriptor.DependsOn {
+			if _, exists := byName[dependency]; !exists {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrUnknownDependency, descriptor.Name, dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(services))
+	ordered := make([]*Descriptor, 0, len(services))
+	path := make([]string, 0)
+
+	var visit func(descriptor *Descriptor) error
+	visit = func(descriptor *Descriptor) error {
+		switch state[descriptor.Name] {
+		case visited:
+			return nil
+		case visiting:
+			cycle := []string{descriptor.Name}
+			for i := len(path) - 1; i >= 0; i-- {
+				cycle = append([]string{path[i]}, cycle...)
+				if path[i] == descriptor.Name {
+					break
+				}
+			}
+			return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(cycle, " -> "))
+		}
+		state[descriptor.Name] = visiting
+		path = append(path, descriptor.Name)
+		for _, dependency := range descriptor.DependsOn {
+			if err := visit(byName[dependency]); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[descriptor.Name] = visited
+		ordered = append(ordered, descriptor)
+		return nil
+	}
+
+	for _, descriptor := range services {
+		if err := visit(descriptor); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
 }
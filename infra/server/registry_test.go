@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type testService struct {
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+}
+
+func (s *testService) Init() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*s.log = append(*s.log, s.name)
+	return nil
+}
+
+func (s *testService) OnConfig() {}
+
+// register the descriptors as the only services for the test
+func register(t *testing.T, descriptors ...*Descriptor) {
+	previous := services
+	services = nil
+	t.Cleanup(func() { services = previous })
+	for _, descriptor := range descriptors {
+		Register(descriptor)
+	}
+}
+
+func descriptor(name string, priority Priority, dependsOn ...string) *Descriptor {
+	return &Descriptor{Name: name, Instance: &testService{name: name}, InitPriority: priority, DependsOn: dependsOn}
+}
+
+func names(descriptors []*Descriptor) []string {
+	names := make([]string, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		names = append(names, descriptor.Name)
+	}
+	return names
+}
+
+func TestGetServicesDependencyOrder(t *testing.T) {
+	register(t,
+		descriptor("repo", High, "postgres", "caches"),
+		descriptor("caches", Low),
+		descriptor("postgres", Inter, "tracing"),
+		descriptor("tracing", Low),
+	)
+	ordered, err := GetServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int)
+	for i, name := range names(ordered) {
+		position[name] = i
+	}
+	for _, d := range ordered {
+		for _, dependency := range d.DependsOn {
+			if position[dependency] > position[d.Name] {
+				t.Errorf("%s initialized before its dependency %s: %v", d.Name, dependency, names(ordered))
+			}
+		}
+	}
+}
+
+func TestGetServicesDependencyCycle(t *testing.T) {
+	register(t,
+		descriptor("a", Low, "b"),
+		descriptor("b", Low, "c"),
+		descriptor("c", Low, "a"),
+		descriptor("d", High),
+	)
+	_, err := GetServices()
+	if !errors.Is(err, ErrDependencyCycle) {
+		t.Fatalf("expected ErrDependencyCycle, got %v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("cycle %q does not name %s", err, name)
+		}
+	}
+}
+
+func TestGetServicesSelfDependency(t *testing.T) {
+	register(t, descriptor("a", Low, "a"))
+	if _, err := GetServices(); !errors.Is(err, ErrDependencyCycle) {
+		t.Fatalf("expected ErrDependencyCycle, got %v", err)
+	}
+}
+
+func TestGetServicesUnknownDependency(t *testing.T) {
+	register(t, descriptor("a", Low, "missing"))
+	if _, err := GetServices(); !errors.Is(err, ErrUnknownDependency) {
+		t.Fatalf("expected ErrUnknownDependency, got %v", err)
+	}
+}
+
+func TestGetServicesDuplicate(t *testing.T) {
+	register(t, descriptor("a", Low), descriptor("a", High))
+	if _, err := GetServices(); !errors.Is(err, ErrDuplicateService) {
+		t.Fatalf("expected ErrDuplicateService, got %v", err)
+	}
+}
+
+func TestInitServicesPriorityWithinLevel(t *testing.T) {
+	var mu sync.Mutex
+	var initialized []string
+	descriptors := []*Descriptor{
+		descriptor("metrics", Low),
+		descriptor("tracing", High),
+		descriptor("caches", Low),
+		descriptor("postgres", High, "tracing"),
+		descriptor("repo", Low, "postgres", "caches"),
+	}
+	for _, d := range descriptors {
+		d.Instance.(*testService).mu = &mu
+		d.Instance.(*testService).log = &initialized
+	}
+	register(t, descriptors...)
+	ordered, err := GetServices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := initServices(ordered); err != nil {
+		t.Fatal(err)
+	}
+
+	// tracing, then metrics and caches in any order, then postgres, then repo
+	if len(initialized) != 5 || initialized[0] != "tracing" || initialized[3] != "postgres" || initialized[4] != "repo" {
+		t.Fatalf("unexpected initialization order %v", initialized)
+	}
+}
@@ -2,12 +2,15 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -17,6 +20,10 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+var (
+	ErrStopFailed = errors.New("Services failed to stop")
+)
+
 type Server struct {
 	context            context.Context
 	shutdownFn         context.CancelFunc
@@ -25,6 +32,21 @@ type Server struct {
 	shutdownInProgress bool
 	homepath           string
 	configpath         string
+	services           []*Descriptor
+	runs               map[string]*backgroundRun
+	mu                 sync.Mutex
+	stopOnce           sync.Once
+	stopErr            error
+}
+
+// backgroundRun of a background service, its context is cancelled when the
+// service is stopped.
+type backgroundRun struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	running  bool
+	stopping bool
+	done     chan struct{}
 }
 
 func init() {
@@ -99,12 +121,23 @@ func onConfigChange(e fsnotify.Event) {
 
 func (server *Server) Run() (err error) {
 
-	services := GetServices()
+	services, err := GetServices()
+	if err != nil {
+		log.WithField("Error", err).Fatal("Resolving services")
+		return err
+	}
 
-	for _, service := range services {
-		if err := service.Instance.Init(); err != nil {
-			log.WithField("Error", err).Fatal("Starting services")
-			return err
+	if err := initServices(services); err != nil {
+		log.WithField("Error", err).Fatal("Starting services")
+		return err
+	}
+	server.services = services
+
+	server.runs = make(map[string]*backgroundRun)
+	for _, svc := range services {
+		if _, ok := svc.Instance.(BackgroundService); ok {
+			ctx, cancel := context.WithCancel(server.context)
+			server.runs[svc.Name] = &backgroundRun{ctx: ctx, cancel: cancel, done: make(chan struct{})}
 		}
 	}
 
@@ -115,21 +148,35 @@ func (server *Server) Run() (err error) {
 		}
 
 		descriptor := svc
+		run := server.runs[descriptor.Name]
 		server.childRoutines.Go(func() error {
-			if server.shutdownInProgress {
+			defer close(run.done)
+			server.mu.Lock()
+			if server.shutdownInProgress || run.stopping {
+				server.mu.Unlock()
 				return nil
 			}
+			run.running = true
+			server.mu.Unlock()
 
-			err := service.Run(server.context)
+			setStatus(descriptor.Name, StatusRunning, nil)
+			err := service.Run(run.ctx)
+			server.mu.Lock()
 			server.shutdownInProgress = true
+			server.mu.Unlock()
 			if err != nil {
 				log.WithField("reason", err.Error()).Errorf("Stopped  %s", descriptor.Name)
+				setStatus(descriptor.Name, StatusFailed, err)
+				setState(stateFailed)
 				return err
 			}
+			setStatus(descriptor.Name, StatusStopped, nil)
 			return nil
 		})
 	}
 
+	setState(stateRunning)
+
 	defer func() {
 		log.Debug("Waiting on services...")
 		if waitErr := server.childRoutines.Wait(); waitErr != nil && reflect.TypeOf(waitErr) != reflect.TypeOf(context.Canceled) {
@@ -138,17 +185,191 @@ func (server *Server) Run() (err error) {
 				err = waitErr
 			}
 		}
+		// Background services may have stopped on their own, release the
+		// remaining services. Waits for a shutdown already in progress.
+		setState(stateShuttingDown)
+		server.stop()
+		if err == nil && server.stopErr != nil {
+			err = server.stopErr
+		}
+		if err == nil && server.shutdownReason != "" {
+			err = context.Canceled
+		}
 	}()
 
 	return
 }
 
+// initServices initializes the services level by level, a level holding the
+// services whose dependencies are all in the levels before it. Within a level
+// the services of a higher priority are initialized first, those of the same
+// priority in parallel. services must be in dependency order as returned by
+// GetServices.
+func initServices(services []*Descriptor) error {
+	for _, wave := range initWaves(services) {
+		group := new(errgroup.Group)
+		for _, svc := range wave {
+			descriptor := svc
+			group.Go(func() error {
+				log.WithField("Service", descriptor.Name).Debug("Initializing service")
+				setStatus(descriptor.Name, StatusInitializing, nil)
+				if err := descriptor.Instance.Init(); err != nil {
+					setStatus(descriptor.Name, StatusFailed, err)
+					return fmt.Errorf("%s: %w", descriptor.Name, err)
+				}
+				setStatus(descriptor.Name, StatusInitialized, nil)
+				return nil
+			})
+		}
+		if err := group.Wait(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initWaves groups the services initialized together, by dependency level
+// then by descending priority.
+func initWaves(services []*Descriptor) [][]*Descriptor {
+	level := make(map[string]int, len(services))
+	for _, descriptor := range services {
+		for _, dependency := range descriptor.DependsOn {
+			if level[dependency]+1 > level[descriptor.Name] {
+				level[descriptor.Name] = level[dependency] + 1
+			}
+		}
+	}
+
+	ordered := append([]*Descriptor(nil), services...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if level[ordered[i].Name] != level[ordered[j].Name] {
+			return level[ordered[i].Name] < level[ordered[j].Name]
+		}
+		return ordered[i].InitPriority > ordered[j].InitPriority
+	})
+
+	waves := make([][]*Descriptor, 0)
+	for i, descriptor := range ordered {
+		if i == 0 || level[descriptor.Name] != level[ordered[i-1].Name] || descriptor.InitPriority != ordered[i-1].InitPriority {
+			waves = append(waves, nil)
+		}
+		waves[len(waves)-1] = append(waves[len(waves)-1], descriptor)
+	}
+	return waves
+}
+
+// stopServices stops the services in reverse initialization order. Each
+// service gets the shutdown.servicetimeout deadline, overridable per service
+// with shutdown.services.<name>, and all of them together shutdown.timeout.
+// A service exceeding its deadline is logged and the next one is stopped.
+func (server *Server) stopServices() error {
+	viper.SetDefault("shutdown.timeout", "30s")
+	viper.SetDefault("shutdown.servicetimeout", "10s")
+	ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("shutdown.timeout"))
+	defer cancel()
+
+	failed := make([]string, 0)
+	for i := len(server.services) - 1; i >= 0; i-- {
+		descriptor := server.services[i]
+		_, stopper := descriptor.Instance.(Stopper)
+		if !stopper && server.runs[descriptor.Name] == nil {
+			continue
+		}
+
+		timeout := viper.GetDuration("shutdown.servicetimeout")
+		if key := "shutdown.services." + strings.ToLower(descriptor.Name); viper.IsSet(key) {
+			timeout = viper.GetDuration(key)
+		}
+		serviceCtx, serviceCancel := context.WithTimeout(ctx, timeout)
+
+		log.WithField("Service", descriptor.Name).Debug("Stopping service")
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- server.stopService(serviceCtx, descriptor)
+		}()
+
+		var err error
+		select {
+		case err = <-stopped:
+		case <-serviceCtx.Done():
+			err = serviceCtx.Err()
+		}
+		serviceCancel()
+
+		if err == context.DeadlineExceeded {
+			log.WithFields(log.Fields{
+				"Service": descriptor.Name,
+				"Timeout": timeout,
+			}).Error("Service exceeded its shutdown deadline")
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"Service": descriptor.Name,
+				"Error":   err,
+			}).Error("Service failed to stop")
+		}
+		if err != nil {
+			failed = append(failed, descriptor.Name)
+			setStatus(descriptor.Name, StatusFailed, err)
+		} else {
+			setStatus(descriptor.Name, StatusStopped, nil)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", ErrStopFailed, strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// stopService calls the Stopper of the service, then cancels the context of
+// its Run and waits for it to return. A background service whose Run was not
+// started is not stopped, it is skipped.
+func (server *Server) stopService(ctx context.Context, descriptor *Descriptor) error {
+	run := server.runs[descriptor.Name]
+	if run != nil {
+		server.mu.Lock()
+		run.stopping = true
+		running := run.running
+		server.mu.Unlock()
+		if !running {
+			return nil
+		}
+	}
+
+	var err error
+	if stopper, ok := descriptor.Instance.(Stopper); ok {
+		err = stopper.Stop(ctx)
+	}
+	if run == nil {
+		return err
+	}
+	run.cancel()
+	select {
+	case <-run.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop releases the services and cancels the background services, only the
+// first call stops, later calls wait for it to complete.
+func (server *Server) stop() {
+	server.stopOnce.Do(func() {
+		server.stopErr = server.stopServices()
+		server.shutdownFn()
+	})
+}
+
 func (server *Server) Shutdown(reason string) {
 
 	log.WithField("Reason", reason).Info("Shutdown started")
+	setState(stateShuttingDown)
 	server.shutdownReason = reason
+	server.mu.Lock()
 	server.shutdownInProgress = true
-	server.shutdownFn()
+	server.mu.Unlock()
+	server.stop()
 
 	if err := server.childRoutines.Wait(); err != nil && reflect.TypeOf(err) != reflect.TypeOf(context.Canceled) {
 		log.WithField("Error", err).Error("Failed waiting for services to shutdown")
@@ -158,9 +379,11 @@ func (server *Server) Shutdown(reason string) {
 func (server *Server) ExitCode(reason error) int {
 
 	code := 1
-	if reason == context.Canceled && server.shutdownReason != "" {
+	if server.stopErr != nil {
+		log.WithField("Error", server.stopErr).Error("Server shutdown incomplete")
+	} else if reason == context.Canceled && server.shutdownReason != "" {
 		code = 0
-	} else {
+	} else if server.shutdownReason == "" {
 		server.shutdownReason = "No Services to listen"
 	}
 	log.WithField("Reason", server.shutdownReason).Error("Server shutdown")
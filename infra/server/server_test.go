@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/sync/errgroup"
+)
+
+// drainingService waits in Stop for its Run to drain, like the bus publisher
+type drainingService struct {
+	testService
+	drained chan struct{}
+	stopped chan struct{}
+}
+
+func (s *drainingService) Run(ctx context.Context) error {
+	defer close(s.drained)
+	select {
+	case <-s.stopped:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (s *drainingService) Stop(ctx context.Context) error {
+	close(s.stopped)
+	select {
+	case <-s.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func newTestServer(t *testing.T, descriptors ...*Descriptor) *Server {
+	viper.Set("shutdown.servicetimeout", "200ms")
+	t.Cleanup(func() { viper.Set("shutdown.servicetimeout", nil) })
+	register(t, descriptors...)
+	rootCtx, shutdownFn := context.WithCancel(context.Background())
+	childRoutines, childCtx := errgroup.WithContext(rootCtx)
+	return &Server{
+		context:       childCtx,
+		shutdownFn:    shutdownFn,
+		childRoutines: childRoutines,
+	}
+}
+
+func newDrainingService(name string) *drainingService {
+	return &drainingService{
+		testService: testService{name: name, mu: new(sync.Mutex), log: new([]string)},
+		drained:     make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+func TestShutdownStopsRunningService(t *testing.T) {
+	service := newDrainingService("publisher")
+	server := newTestServer(t, &Descriptor{Name: "publisher", Instance: service})
+	done := make(chan error, 1)
+	go func() { done <- server.Run() }()
+
+	deadline := time.Now().Add(time.Second)
+	for statusOf("publisher") != StatusRunning && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	server.Shutdown("test")
+	<-done
+	if server.stopErr != nil {
+		t.Fatalf("stopping failed: %v", server.stopErr)
+	}
+	if server.ExitCode(context.Canceled) != 0 {
+		t.Fatal("expected a zero exit code")
+	}
+}
+
+func TestShutdownSkipsServiceNotRun(t *testing.T) {
+	service := newDrainingService("publisher")
+	server := newTestServer(t, &Descriptor{Name: "publisher", Instance: service})
+	server.shutdownInProgress = true
+	done := make(chan error, 1)
+	go func() { done <- server.Run() }()
+	<-done
+
+	if server.stopErr != nil {
+		t.Fatalf("stopping a service which did not run failed: %v", server.stopErr)
+	}
+	select {
+	case <-service.stopped:
+		t.Fatal("Stop called on a service which did not run")
+	default:
+	}
+}
+
+func statusOf(name string) Status {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	return statuses[name].status
+}
@@ -0,0 +1,73 @@
+package server
+
+import "sync"
+
+// Status of a service in its lifecycle
+type Status string
+
+const (
+	StatusRegistered   Status = "registered"
+	StatusInitializing Status = "initializing"
+	StatusInitialized  Status = "initialized"
+	StatusRunning      Status = "running"
+	StatusStopped      Status = "stopped"
+	StatusFailed       Status = "failed"
+)
+
+// ServiceInfo describes a registered service for introspection
+type ServiceInfo struct {
+	Name          string
+	Priority      Priority
+	DependsOn     []string
+	Status        Status
+	Error         string
+	Background    bool
+	Stopper       bool
+	HealthChecker bool
+}
+
+type serviceStatus struct {
+	status Status
+	err    error
+}
+
+var (
+	statusMu sync.RWMutex
+	statuses = make(map[string]serviceStatus)
+)
+
+// Services describes the registered services, in initialization order once
+// the server runs.
+func Services() []ServiceInfo {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+	infos := make([]ServiceInfo, 0, len(services))
+	for _, descriptor := range services {
+		_, background := descriptor.Instance.(BackgroundService)
+		_, stopper := descriptor.Instance.(Stopper)
+		_, healthChecker := descriptor.Instance.(HealthChecker)
+		info := ServiceInfo{
+			Name:          descriptor.Name,
+			Priority:      descriptor.InitPriority,
+			DependsOn:     descriptor.DependsOn,
+			Status:        StatusRegistered,
+			Background:    background,
+			Stopper:       stopper,
+			HealthChecker: healthChecker,
+		}
+		if status, ok := statuses[descriptor.Name]; ok {
+			info.Status = status.status
+			if status.err != nil {
+				info.Error = status.err.Error()
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func setStatus(name string, status Status, err error) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	statuses[name] = serviceStatus{status: status, err: err}
+}
@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"go-microservice/infra/server"
+	"io"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpgrpc"
+	"go.opentelemetry.io/otel/exporters/stdout"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go-microservice"
+
+var (
+	instance           *tracing
+	ErrUnknownExporter = errors.New("Unknown tracing exporter")
+)
+
+type config struct {
+	Exporter    string  `json:"exporter"`
+	Endpoint    string  `json:"endpoint"`
+	File        string  `json:"file"`
+	SampleRatio float64 `json:"sampleratio"`
+}
+
+type tracing struct {
+	config   config
+	provider *sdktrace.TracerProvider
+	file     io.Closer
+}
+
+func init() {
+	instance = &tracing{}
+	server.RegisterService(instance, server.High)
+}
+
+// Init installs the W3C trace context propagator and, unless the exporter is
+// none, a tracer provider exporting spans to stdout, a file or an OTLP
+// collector.
+func (c *tracing) Init() (err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	viper.SetDefault("tracing.exporter", "none")
+	viper.SetDefault("tracing.endpoint", "127.0.0.1:4317")
+	viper.SetDefault("tracing.file", "logs/traces.json")
+	viper.SetDefault("tracing.sampleratio", 1.0)
+	c.config = config{
+		Exporter:    viper.GetString("tracing.exporter"),
+		Endpoint:    viper.GetString("tracing.endpoint"),
+		File:        viper.GetString("tracing.file"),
+		SampleRatio: viper.GetFloat64("tracing.sampleratio"),
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch c.config.Exporter {
+	case "none", "":
+		log.Info("Tracing disabled, propagating trace context only")
+		return nil
+	case "stdout":
+		exporter, err = stdout.NewExporter(stdout.WithPrettyPrint(), stdout.WithoutMetricExport())
+	case "file":
+		exporter, err = c.fileExporter()
+	case "otlp":
+		driver := otlpgrpc.NewDriver(
+			otlpgrpc.WithInsecure(),
+			otlpgrpc.WithEndpoint(c.config.Endpoint),
+		)
+		exporter, err = otlp.NewExporter(context.Background(), driver)
+	default:
+		return ErrUnknownExporter
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"Error":    err,
+			"Exporter": c.config.Exporter,
+		}).Error("Tracing exporter failed")
+		return err
+	}
+
+	viper.SetDefault("application", tracerName)
+	c.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.config.SampleRatio))),
+		sdktrace.WithResource(sdkresource.NewWithAttributes(
+			semconv.ServiceNameKey.String(viper.GetString("application")),
+		)),
+	)
+	otel.SetTracerProvider(c.provider)
+	log.WithField("Exporter", c.config.Exporter).Info("Tracing enabled")
+	return nil
+}
+
+func (c *tracing) fileExporter() (sdktrace.SpanExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(c.config.File), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(c.config.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	return stdout.NewExporter(stdout.WithWriter(file), stdout.WithoutMetricExport())
+}
+
+func (c *tracing) OnConfig() {
+}
+
+// Stop flushes the spans not yet exported
+func (c *tracing) Stop(ctx context.Context) error {
+	if c.provider == nil {
+		return nil
+	}
+	err := c.provider.Shutdown(ctx)
+	if c.file != nil {
+		c.file.Close()
+	}
+	return err
+}
+
+// Start a span as a child of the span in ctx, End it once done.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// End the span recording err when the operation failed.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject the trace context of ctx into headers carried with a message
+func Inject(ctx context.Context) map[string]string {
+	headers := mapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+	return headers
+}
+
+// Extract the trace context of headers injected by Inject into ctx
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, mapCarrier(headers))
+}
+
+type mapCarrier map[string]string
+
+func (c mapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c mapCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c mapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -5,6 +5,8 @@ import (
 	_ "go-microservice/infra/cache"
 	_ "go-microservice/infra/dbs/postgres"
 	_ "go-microservice/infra/gateway"
+	_ "go-microservice/infra/metrics"
+	_ "go-microservice/infra/tracing"
 	"go-microservice/infra/server"
 	_ "go-microservice/repository"
 	_ "go-microservice/services"
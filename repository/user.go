@@ -1,9 +1,11 @@
 package repository
 
 import (
+	"context"
 	"go-microservice/dtos"
 	"go-microservice/infra/bus"
 	"go-microservice/infra/cache"
+	"go-microservice/infra/cache/warmup"
 	"go-microservice/infra/dbs/postgres"
 	"go-microservice/infra/server"
 	"time"
@@ -13,18 +15,34 @@ import (
 
 type userRepo struct{}
 
+var users = cache.NewNamespace("users")
+
 func init() {
-	server.RegisterService(&userRepo{}, server.Low)
+	server.RegisterService(&userRepo{}, server.Low, "postgres", "caches")
 }
 
 func (c *userRepo) Init() (err error) {
 
 	c.addUserMigrations()
+	warmup.Register(warmup.Warmer{
+		Name:    "user count",
+		Pattern: users.Key("count"),
+		Load:    countUsers,
+		TTL:     cache.ForEverNeverExpiry,
+		Refresh: 5 * time.Minute,
+	})
 
 	//Register for all the repository requests
-	bus.AddHandler(CreateUser)
-	bus.AddHandler(ListUsers)
-	return nil
+	if err := bus.AddHandlerCtx(CreateUser); err != nil {
+		return err
+	}
+	if err := bus.UseFor(&dtos.CreateUserCmd{}, bus.Validation); err != nil {
+		return err
+	}
+	if err := bus.Forward(&dtos.UserCreatedEvent{}); err != nil {
+		return err
+	}
+	return bus.AddQueryHandler(ListUsers)
 }
 
 func (c *userRepo) OnConfig() {
@@ -51,44 +69,56 @@ func (c *userRepo) addUserMigrations() {
 	}))
 }
 
-func CreateUser(cmd *dtos.CreateUserCmd) error {
-	db, err := postgres.DB()
-	if err != nil {
-		return err
-	}
-	go cache.Delete(false, "userscount")
-	return db.Transaction(func(tx *gorm.DB) error {
+func CreateUser(ctx context.Context, cmd *dtos.CreateUserCmd) error {
+	err := postgres.Transaction(ctx, func(tx *gorm.DB) error {
 		user := dtos.User{
 			Name:    cmd.Name,
 			Email:   cmd.Email,
 			Created: time.Now(),
 			Updated: time.Now(),
 		}
-		err := tx.Create(&user).Error
-		if err == nil {
-			cmd.Result = &user
+		if err := tx.Create(&user).Error; err != nil {
+			return err
 		}
-		return err
+		cmd.Result = &user
+		return bus.PublishTx(tx, &dtos.UserCreatedEvent{
+			Id:    user.Id,
+			Name:  user.Name,
+			Email: user.Email,
+		})
 	})
-	return nil
+	if err != nil {
+		return err
+	}
+	return cache.Invalidate(false, users.Key("count"))
 }
 
-func ListUsers(cmd *dtos.ListUsersCmd) error {
+func ListUsers(ctx context.Context, query *dtos.ListUsersQuery) (dtos.UsersResult, error) {
 	var userCount int64
 	userCount = 0
-	db, err := postgres.DB()
+	result := dtos.UsersResult{Users: make([]*dtos.User, 0)}
+	db, err := postgres.WithContext(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
-	err = cache.Get(false, "userscount", &userCount)
+	err = cache.GetOrLoadCtx(ctx, false, users.Key("count"), &userCount, cache.ForEverNeverExpiry, countUsers)
 	if err != nil {
-		db.Table("user").Count(&userCount)
-		go cache.Set(false, "userscount", userCount, cache.ForEverNeverExpiry)
+		return result, err
 	}
-	cmd.Result.Users = make([]*dtos.User, 0)
-	if cmd.Limit*(cmd.Page-1) < userCount {
-		err := db.Offset(cmd.Limit * (cmd.Page - 1)).Limit(cmd.Limit).Find(&cmd.Result.Users).Error
-		return err
+	if query.Limit*(query.Page-1) < userCount {
+		err := db.Offset(query.Limit * (query.Page - 1)).Limit(query.Limit).Find(&result.Users).Error
+		return result, err
+	}
+	return result, nil
+}
+
+// countUsers is the value of users.Key("count")
+func countUsers(ctx context.Context, key string) (interface{}, error) {
+	db, err := postgres.WithContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	var count int64
+	err = db.Table("user").Count(&count).Error
+	return count, err
 }
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	gw "go-microservice/generated/gateway/proto"
+	"go-microservice/generated/proto"
+	"go-microservice/infra/bus"
+	"go-microservice/infra/dbs/postgres"
+	"go-microservice/infra/gateway"
+	"go-microservice/infra/server"
+
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// AdminService reports the registered services, bus handlers and migrations,
+// served on Grpc and at /debug on REST unless admin.enabled is false.
+type AdminService struct{}
+
+func init() {
+	server.RegisterService(&AdminService{}, server.Low, "GRPC", "REST")
+}
+
+func (service *AdminService) Init() (err error) {
+	viper.SetDefault("admin.enabled", viper.GetString("mode") != "prod")
+	if !viper.GetBool("admin.enabled") {
+		return nil
+	}
+	proto.RegisterAdminServiceServer(gateway.Grpc(), service)
+	return gw.RegisterAdminServiceHandler(context.Background(), gateway.Mux(), gateway.ClientConnection())
+}
+
+func (service *AdminService) OnConfig() {
+}
+
+func (service *AdminService) ListServices(ctx context.Context, request *proto.ListServicesRequest) (*proto.ListServicesResponse, error) {
+	response := &proto.ListServicesResponse{}
+	for _, info := range server.Services() {
+		response.Services = append(response.Services, &proto.ServiceDescriptor{
+			Name:          info.Name,
+			Priority:      int32(info.Priority),
+			DependsOn:     info.DependsOn,
+			Status:        string(info.Status),
+			Error:         info.Error,
+			Background:    info.Background,
+			Stopper:       info.Stopper,
+			HealthChecker: info.HealthChecker,
+		})
+	}
+	return response, nil
+}
+
+func (service *AdminService) ListBusHandlers(ctx context.Context, request *proto.ListBusHandlersRequest) (*proto.ListBusHandlersResponse, error) {
+	response := &proto.ListBusHandlersResponse{}
+	for _, info := range bus.Handlers() {
+		handler := &proto.BusHandler{
+			Kind:     info.Kind,
+			Message:  info.Message,
+			Function: info.Function,
+		}
+		if info.Policy != nil {
+			handler.Policy = &proto.ListenerPolicy{
+				MaxAttempts: int32(info.Policy.MaxAttempts),
+				Backoff:     info.Policy.Backoff.String(),
+				MaxBackoff:  info.Policy.MaxBackoff.String(),
+				Timeout:     info.Policy.Timeout.String(),
+				DeadLetter:  info.Policy.DeadLetter,
+			}
+		}
+		response.Handlers = append(response.Handlers, handler)
+	}
+	return response, nil
+}
+
+func (service *AdminService) ListMigrations(ctx context.Context, request *proto.ListMigrationsRequest) (*proto.ListMigrationsResponse, error) {
+	logItems, err := postgres.MigrationLogs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	response := &proto.ListMigrationsResponse{}
+	for _, logItem := range logItems {
+		response.Migrations = append(response.Migrations, &proto.Migration{
+			Id:          logItem.Id,
+			MigrationId: logItem.MigrationId,
+			Sql:         logItem.Sql,
+			Success:     logItem.Success,
+			Error:       logItem.Error,
+			Timestamp:   timestamppb.New(logItem.Timestamp),
+		})
+	}
+	return response, nil
+}
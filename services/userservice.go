@@ -9,8 +9,6 @@ import (
 	"go-microservice/infra/gateway"
 	"go-microservice/infra/server"
 	"sync"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type UserService struct {
@@ -20,7 +18,7 @@ type UserService struct {
 func init() {
 	server.RegisterService(&UserService{
 		mu: &sync.RWMutex{},
-	}, server.Low)
+	}, server.Low, "GRPC", "REST")
 }
 
 func (service *UserService) Init() (err error) {
@@ -45,8 +43,7 @@ func (service *UserService) AddUser(ctx context.Context, request *proto.AddUserR
 		Name:  request.GetName(),
 		Email: request.GetEmail(),
 	}
-	if err := bus.Dispatch(&cmd); err != nil {
-		log.WithField("Error", err).Error("Add user failed")
+	if err := bus.DispatchCtx(ctx, &cmd); err != nil {
 		return nil, err
 	}
 	user := proto.AddUserResponse{
@@ -61,15 +58,15 @@ func (service *UserService) ListUsers(request *proto.ListUsersRequest, srv proto
 	service.mu.RLock()
 	defer service.mu.RUnlock()
 
-	cmd := dtos.ListUsersCmd{
+	query := dtos.ListUsersQuery{
 		Limit: request.GetLimit(),
 		Page:  request.GetPage(),
 	}
-	if err := bus.Dispatch(&cmd); err != nil {
-		log.WithField("Error", err).Error("List users failed")
+	var result dtos.UsersResult
+	if err := bus.Query(srv.Context(), &query, &result); err != nil {
 		return err
 	}
-	for _, user := range cmd.Result.Users {
+	for _, user := range result.Users {
 		err := srv.Send(&proto.ListUsersResponse{
 			Id:    user.Id,
 			Name:  user.Name,